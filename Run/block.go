@@ -0,0 +1,222 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// A block is a runnable piece of a window body: a Markdown fenced code
+// block, the region between two cell markers or a paragraph.
+type block struct {
+	src    string
+	q0, q1 int    // rune offsets of the block in the body, fences included
+	text   string // of the body from q0 to q1
+	line   int    // line of the body, from 1, where src starts
+	lang   string // language of a fenced block
+	fenced bool
+}
+
+// A bodyLine is a line of a body and the rune offsets where it starts and
+// where the next one does.
+type bodyLine struct {
+	text   string
+	q0, q1 int
+}
+
+func splitLines(body string) []bodyLine {
+	var lines []bodyLine
+	q := 0
+	for body != "" {
+		text, rest, nl := strings.Cut(body, "\n")
+		l := bodyLine{text: text, q0: q, q1: q + utf8.RuneCountInString(text)}
+		if nl {
+			l.q1++
+		}
+		lines = append(lines, l)
+		q = l.q1
+		body = rest
+	}
+	return lines
+}
+
+// blockAt returns the block of body around the rune offset q. Fenced
+// blocks come first, then the regions between lines starting with one of
+// markers, then paragraphs. It reports false when q is on no block.
+func blockAt(body string, q int, markers []string) (block, bool) {
+	lines := splitLines(body)
+	cur := len(lines) - 1
+	for i := range lines {
+		if q < lines[i].q1 {
+			cur = i
+			break
+		}
+	}
+	if cur < 0 {
+		return block{}, false
+	}
+	// dot on results is on the block they belong to
+	if r := resultsAround(lines, cur); r >= 0 {
+		if cur = r - 1; cur < 0 {
+			return block{}, false
+		}
+	}
+
+	// fenced code blocks
+	isFence := func(l bodyLine) bool {
+		t := strings.TrimSpace(l.text)
+		return strings.HasPrefix(t, "```") || strings.HasPrefix(t, "~~~")
+	}
+	open := -1
+	for i, l := range lines {
+		if !isFence(l) {
+			continue
+		}
+		if open < 0 {
+			open = i
+			continue
+		}
+		if open <= cur && cur <= i {
+			b := makeBlock(lines, open+1, i-1)
+			b.q0, b.q1 = lines[open].q0, lines[i].q1
+			b.text = linesText(lines, open, i)
+			b.fenced = true
+			lang := strings.Trim(strings.TrimSpace(lines[open].text), "`~ {}.")
+			if f := strings.Fields(lang); len(f) > 0 {
+				b.lang = f[0]
+			}
+			return b, true
+		}
+		open = -1
+	}
+
+	// cells
+	isMarker := func(l bodyLine) bool {
+		t := strings.TrimSpace(l.text)
+		for _, m := range markers {
+			if strings.HasPrefix(t, m) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, l := range lines {
+		if !isMarker(l) {
+			continue
+		}
+		start, end := cur, cur
+		if isMarker(lines[cur]) {
+			start++
+		} else {
+			for start > 0 && !isMarker(lines[start-1]) {
+				start--
+			}
+		}
+		for end+1 < len(lines) && !isMarker(lines[end+1]) {
+			end++
+		}
+		if start > end {
+			return block{}, false
+		}
+		return makeBlock(lines, start, end), true
+	}
+
+	// paragraphs, which end at fences and results too
+	blank := func(i int) bool {
+		t := strings.TrimSpace(lines[i].text)
+		return t == "" || t == resultsEnd || isFence(lines[i])
+	}
+	if blank(cur) {
+		return block{}, false
+	}
+	start, end := cur, cur
+	for start > 0 && !blank(start-1) {
+		start--
+	}
+	for end+1 < len(lines) && !blank(end+1) {
+		end++
+	}
+	return makeBlock(lines, start, end), true
+}
+
+// resultsAround returns the line where the results holding the line cur
+// start, or -1 if cur is not in results.
+func resultsAround(lines []bodyLine, cur int) int {
+	for i := cur; i >= 0; i-- {
+		if isResults(lines[i].text) {
+			if end, ok := resultsAfter(lines, i); ok && lines[cur].q0 < end {
+				return i
+			}
+			return -1
+		}
+		if i < cur && strings.TrimSpace(lines[i].text) == resultsEnd {
+			return -1
+		}
+	}
+	return -1
+}
+
+// makeBlock returns the block made of lines start to end. Results written
+// by -R end the block.
+func makeBlock(lines []bodyLine, start, end int) block {
+	for i := start; i <= end; i++ {
+		if isResults(lines[i].text) {
+			end = i - 1
+			break
+		}
+	}
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		b.WriteString(lines[i].text)
+		b.WriteByte('\n')
+	}
+	q0, q1 := lines[start].q0, lines[start].q0
+	if end >= start {
+		q1 = lines[end].q1
+	}
+	return block{src: b.String(), q0: q0, q1: q1, text: linesText(lines, start, end), line: start + 1}
+}
+
+// linesText returns the text of lines start to end as it is in the body.
+func linesText(lines []bodyLine, start, end int) string {
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		b.WriteString(lines[i].text)
+		if lines[i].q1-lines[i].q0 > utf8.RuneCountInString(lines[i].text) {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// find returns the rune offsets of b in body, which may have changed
+// since b was taken from it: the place, starting a line, where its text
+// is that is the nearest to where it was. It reports false when the text
+// is no longer there.
+func (b block) find(body string) (int, int, bool) {
+	q0, found := 0, false
+	for i := 0; i <= len(body); {
+		j := strings.Index(body[i:], b.text)
+		if j < 0 {
+			break
+		}
+		i += j
+		if i == 0 || body[i-1] == '\n' {
+			q := utf8.RuneCountInString(body[:i])
+			if !found || abs(q-b.q0) < abs(q0-b.q0) {
+				q0, found = q, true
+			}
+		}
+		i++
+	}
+	if !found {
+		return 0, 0, false
+	}
+	return q0, q0 + utf8.RuneCountInString(b.text), true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
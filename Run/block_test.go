@@ -0,0 +1,101 @@
+package main
+
+import "testing"
+
+func TestBlockAt(t *testing.T) {
+	const fenced = "text\n\n```python\nprint(1)\n```\n#+RESULTS:\n1\n#+END\n"
+	tests := []struct {
+		name    string
+		body    string
+		line    int // line of dot, from 0
+		markers []string
+		src     string
+		lang    string
+		ok      bool
+	}{
+		{"fenced", fenced, 3, nil, "print(1)\n", "python", true},
+		{"fence line", fenced, 2, nil, "print(1)\n", "python", true},
+		{"fenced results", fenced, 6, nil, "print(1)\n", "python", true},
+		{"fenced results header", fenced, 5, nil, "print(1)\n", "python", true},
+		{"fenced results end", fenced, 7, nil, "print(1)\n", "python", true},
+		{"prose", fenced, 0, nil, "text\n", "", true},
+		{"blank", fenced, 1, nil, "", "", false},
+		{"prose below fence", "```\nls\n```\necho hi\n", 3, nil, "echo hi\n", "", true},
+		{"paragraph", "a\nb\n\nc\nd\n", 4, nil, "c\nd\n", "", true},
+		{"paragraph results", "a\nb\n#+RESULTS:\nx\n#+END\n\nc\n", 3, nil, "a\nb\n", "", true},
+		{"error results", "a\n#+ERROR: exit status 1\nx\n#+END\n", 2, nil, "a\n", "", true},
+		{"results on top", "#+RESULTS:\nx\n#+END\n", 1, nil, "", "", false},
+		{"after results", "a\n#+RESULTS:\nx\n#+END\nb\n", 4, nil, "b\n", "", true},
+		{"cell", "x=1\n#%%\ny=2\nz=3\n#%%\nw=4\n", 2, []string{"#%%"}, "y=2\nz=3\n", "", true},
+		{"first cell", "x=1\n#%%\ny=2\n", 0, []string{"#%%"}, "x=1\n", "", true},
+		{"marker line", "x=1\n#%%\ny=2\n", 1, []string{"#%%"}, "y=2\n", "", true},
+		{"spaced marker", "x=1\n# %%\ny=2\n", 2, []string{"#%%", "# %%"}, "y=2\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := splitLines(tt.body)[tt.line].q0
+			b, ok := blockAt(tt.body, q, tt.markers)
+			if ok != tt.ok {
+				t.Fatalf("blockAt(%q, %d) ok = %v, want %v", tt.body, q, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if b.src != tt.src || b.lang != tt.lang {
+				t.Errorf("blockAt(%q, %d) = %q %q, want %q %q", tt.body, q, b.src, b.lang, tt.src, tt.lang)
+			}
+		})
+	}
+}
+
+func TestMakeBlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		start, end int
+		src        string
+		q0, q1     int
+		line       int
+	}{
+		{"lines", "a\nbc\nd\n", 1, 2, "bc\nd\n", 2, 7, 2},
+		{"runes", "é\nü\n", 1, 1, "ü\n", 2, 4, 2},
+		{"results", "a\nb\n#+RESULTS:\nx\n#+END\n", 0, 4, "a\nb\n", 0, 4, 1},
+		{"only results", "#+RESULTS:\nx\n", 0, 1, "", 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := makeBlock(splitLines(tt.body), tt.start, tt.end)
+			if b.src != tt.src || b.q0 != tt.q0 || b.q1 != tt.q1 || b.line != tt.line {
+				t.Errorf("makeBlock = %q #%d,#%d line %d, want %q #%d,#%d line %d",
+					b.src, b.q0, b.q1, b.line, tt.src, tt.q0, tt.q1, tt.line)
+			}
+		})
+	}
+}
+
+func TestBlockFind(t *testing.T) {
+	const body = "x\n```sh\nls\n```\n"
+	b, _ := blockAt(body, 3, nil)
+	tests := []struct {
+		name   string
+		body   string
+		q0, q1 int
+		ok     bool
+	}{
+		{"same", body, 2, 15, true},
+		{"text added above", "é\n\nx\n```sh\nls\n```\n", 5, 18, true},
+		{"text deleted above", "```sh\nls\n```\n", 0, 13, true},
+		{"nearest", "```sh\nls\n```\nx\n```sh\nls\n```\n", 0, 13, true},
+		{"edited", "x\n```sh\nls -l\n```\n", 0, 0, false},
+		{"deleted", "x\n", 0, 0, false},
+		{"not at a line start", "x```sh\nls\n```\n", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q0, q1, ok := b.find(tt.body)
+			if ok != tt.ok || ok && (q0 != tt.q0 || q1 != tt.q1) {
+				t.Errorf("find(%q) = #%d,#%d %v, want #%d,#%d %v", tt.body, q0, q1, ok, tt.q0, tt.q1, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// errLines match the error messages of the known interpreters; the first
+// group is the line of the script. A script given in a file is named by
+// its path, which is matched apart.
+var errLines = []*regexp.Regexp{
+	regexp.MustCompile(`File "<(?:string|run|stdin)>", line (\d+)`), // python
+	regexp.MustCompile(`^(?:sh|dash): (\d+): `),                     // sh -c
+	regexp.MustCompile(`^bash: line (\d+): `),                       // bash -c
+	regexp.MustCompile(`^rc: (?:[^:\s]*:)?(?:line )?(\d+): `),       // rc
+	regexp.MustCompile(`^awk: cmd\. line:(\d+): `),                  // gawk
+	regexp.MustCompile(` source line number (\d+)`),                 // awk
+	regexp.MustCompile(` at -e line (\d+)\.`),                       // perl -e
+	regexp.MustCompile(`^\[eval\]:(\d+)`),                           // node -e
+}
+
+// sessionErrLines are the errLines meaningful in a session. The shells
+// count lines over the whole session, only the python driver compiles
+// every script apart.
+var sessionErrLines = errLines[:1]
+
+// errMapper copies the standard error of a script to w, prefixing the lines
+// naming a line of the script with name:line, the line of the window.
+type errMapper struct {
+	w      io.Writer
+	name   string
+	offset int
+	file   *regexp.Regexp // script file, if any
+	lines  []*regexp.Regexp
+	buf    []byte
+}
+
+// newErrMapper returns an errMapper for a script starting at line of the
+// window name, run from file if it is not "".
+func newErrMapper(w io.Writer, name string, line int, file string) *errMapper {
+	m := &errMapper{w: w, name: name, offset: line - 1, lines: errLines}
+	if file != "" {
+		m.file = regexp.MustCompile(regexp.QuoteMeta(file) + `(?:", line |: line |:| line )(\d+)`)
+	}
+	return m
+}
+
+func (m *errMapper) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	for {
+		i := bytes.IndexByte(m.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if _, err := m.w.Write(m.mapLine(m.buf[:i+1])); err != nil {
+			return 0, err
+		}
+		m.buf = m.buf[i+1:]
+	}
+}
+
+// Flush writes what is left of an unfinished line.
+func (m *errMapper) Flush() error {
+	if len(m.buf) == 0 {
+		return nil
+	}
+	_, err := m.w.Write(m.mapLine(m.buf))
+	m.buf = nil
+	return err
+}
+
+func (m *errMapper) mapLine(line []byte) []byte {
+	res := m.lines
+	if m.file != nil {
+		res = append([]*regexp.Regexp{m.file}, res...)
+	}
+	for _, re := range res {
+		sub := re.FindSubmatch(line)
+		if sub == nil {
+			continue
+		}
+		n, err := strconv.Atoi(string(sub[1]))
+		if err != nil {
+			continue
+		}
+		return append([]byte(fmt.Sprintf("%s:%d: ", m.name, n+m.offset)), line...)
+	}
+	return line
+}
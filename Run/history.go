@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"plramos.win/9fans/acme"
+)
+
+// Every run is recorded in a directory of histDir named after its start
+// time. It holds the script, its output and a meta file of key value
+// lines. Only the newest runs, as many as the history option says, are
+// kept.
+var histDir = filepath.Join(os.Getenv("HOME"), "lib", "run.hist")
+
+const histStamp = "20060102-150405.000000"
+
+// lockedBuffer is a bytes.Buffer safe to write from the goroutines
+// copying the standard output and error.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+// record saves the run of j, started at start, with output out and ending
+// with err, then drops the oldest runs over keep.
+func record(j *job, start time.Time, out []byte, err error) error {
+	dir := filepath.Join(histDir, start.Format(histStamp))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	status := "0"
+	if err != nil {
+		status = err.Error()
+	}
+	var meta strings.Builder
+	fmt.Fprintf(&meta, "target %s\n", j.name)
+	fmt.Fprintf(&meta, "dir %s\n", j.dir)
+	fmt.Fprintf(&meta, "interp %s\n", j.in)
+	fmt.Fprintf(&meta, "line %d\n", j.line)
+	if j.winid != 0 {
+		fmt.Fprintf(&meta, "winid %d\n", j.winid)
+	}
+	for _, kv := range j.env {
+		fmt.Fprintf(&meta, "env %s\n", strconv.Quote(kv))
+	}
+	if j.remote != "" {
+		fmt.Fprintf(&meta, "remote %s\n", j.remote)
+	}
+	if j.input != "" {
+		fmt.Fprintf(&meta, "input %s\n", j.input)
+	}
+	if j.session {
+		fmt.Fprintf(&meta, "session %d\n", j.winid)
+	}
+	if j.timeout > 0 {
+		fmt.Fprintf(&meta, "timeout %v\n", j.timeout)
+	}
+	fmt.Fprintf(&meta, "hash %x\n", sha256.Sum256([]byte(j.src)))
+	fmt.Fprintf(&meta, "start %s\n", start.Format(time.RFC3339Nano))
+	fmt.Fprintf(&meta, "end %s\n", time.Now().Format(time.RFC3339Nano))
+	fmt.Fprintf(&meta, "status %s\n", status)
+	files := map[string][]byte{
+		"meta":   []byte(meta.String()),
+		"script": []byte(j.src),
+		"output": out,
+	}
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+			return err
+		}
+	}
+
+	runs := histRuns()
+	for i := j.keep; i < len(runs); i++ {
+		os.RemoveAll(filepath.Join(histDir, runs[i]))
+	}
+	return nil
+}
+
+// histRuns returns the recorded runs, newest first.
+func histRuns() []string {
+	entries, _ := os.ReadDir(histDir)
+	var runs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			runs = append(runs, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(runs)))
+	return runs
+}
+
+// histMeta reads the meta file of the run. Of the keys given several
+// times, like env, the last value is kept.
+func histMeta(run string) map[string]string {
+	meta := make(map[string]string)
+	for _, line := range histLines(run) {
+		if k, v, ok := strings.Cut(line, " "); ok {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// histEnv reads the environment additions recorded for the run.
+func histEnv(run string) ([]string, error) {
+	var env []string
+	for _, line := range histLines(run) {
+		if q, ok := strings.CutPrefix(line, "env "); ok {
+			kv, err := strconv.Unquote(q)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad env %s", run, q)
+			}
+			env = append(env, kv)
+		}
+	}
+	return env, nil
+}
+
+// histLines returns the lines of the meta file of the run.
+func histLines(run string) []string {
+	b, _ := os.ReadFile(filepath.Join(histDir, run, "meta"))
+	return strings.Split(string(b), "\n")
+}
+
+// histJob makes the job running again the script of run, where and how
+// it ran.
+func histJob(run string, keep int) (*job, error) {
+	src, err := os.ReadFile(filepath.Join(histDir, run, "script"))
+	if err != nil {
+		return nil, err
+	}
+	meta := histMeta(run)
+	args := strings.Fields(meta["interp"])
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: no interpreter recorded", run)
+	}
+	j := &job{
+		name:   meta["target"],
+		src:    string(src),
+		in:     interp{name: filepath.Base(args[0]), args: args},
+		dir:    meta["dir"],
+		keep:   keep,
+		remote: meta["remote"],
+		input:  meta["input"],
+		line:   1,
+	}
+	if l, ok := meta["line"]; ok {
+		if j.line, err = strconv.Atoi(l); err != nil || j.line < 1 {
+			return nil, fmt.Errorf("%s: bad line %s", run, l)
+		}
+	}
+	if id, ok := meta["winid"]; ok {
+		if j.winid, err = strconv.Atoi(id); err != nil {
+			return nil, fmt.Errorf("%s: bad winid %s", run, id)
+		}
+	}
+	if id, ok := meta["session"]; ok {
+		if j.winid, err = strconv.Atoi(id); err != nil {
+			return nil, fmt.Errorf("%s: bad session %s", run, id)
+		}
+		j.session = true
+	}
+	if j.env, err = histEnv(run); err != nil {
+		return nil, err
+	}
+	if t, ok := meta["timeout"]; ok {
+		if j.timeout, err = time.ParseDuration(t); err != nil {
+			return nil, fmt.Errorf("%s: bad timeout %s", run, t)
+		}
+	}
+	return j, nil
+}
+
+// history lists the recorded runs, newest first. Clicking the stamp of a
+// run with button 2 or 3 opens its script and output; Again runs the
+// selected one, or the one on the line of dot, again.
+func history(conf *config) error {
+	w, err := acme.New()
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+	winName := "/Run/+history"
+	w.Name(winName)
+	w.Fprintf("tag", "Get Again")
+
+	var runs []string
+	list := func() {
+		runs = histRuns()
+		w.Clear()
+		for _, run := range runs {
+			meta := histMeta(run)
+			start, _ := time.Parse(time.RFC3339Nano, meta["start"])
+			end, _ := time.Parse(time.RFC3339Nano, meta["end"])
+			w.Fprintf("body", "%s\t%s\t%v\t%s\n", run, meta["status"], end.Sub(start).Round(time.Millisecond), meta["target"])
+		}
+		w.Ctl("clean")
+		w.Addr("0,0")
+		w.Ctl("dot=addr")
+	}
+	isRun := func(s string) bool {
+		for _, run := range runs {
+			if run == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the +Run windows opened are served until deleted, even after w
+	var wg sync.WaitGroup
+	list()
+	for e := range w.EventChan() {
+		switch {
+		case e.C2 == 'x' && string(e.Text) == "Get":
+			list()
+		case e.C2 == 'x' && string(e.Text) == "Again":
+			run := selectedRun(w)
+			if !isRun(run) {
+				acme.Errf(winName, "No run selected")
+				continue
+			}
+			j, err := histJob(run, conf.history)
+			if err != nil {
+				acme.Errf(winName, "%v", err)
+				continue
+			}
+			o, err := openOutWin(j.name)
+			if err != nil {
+				acme.Errf(winName, "%v", err)
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				o.serve(j, nil)
+			}()
+		case (e.C2 == 'X' || e.C2 == 'L') && isRun(string(e.Text)):
+			for _, file := range []string{"script", "output"} {
+				fw, err := acme.New()
+				if err != nil {
+					continue
+				}
+				fw.Name(filepath.Join(histDir, string(e.Text), file))
+				fw.Ctl("get")
+				fw.CloseFiles()
+			}
+		default:
+			w.WriteEvent(e)
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// selectedRun returns the first word of the selection of w or, without
+// one, of the line of dot.
+func selectedRun(w *acme.Win) string {
+	text := w.Selection()
+	if strings.TrimSpace(text) == "" {
+		q0, _, err := w.SelectionAddr()
+		if err != nil {
+			return ""
+		}
+		body, err := w.ReadAll("body")
+		if err != nil {
+			return ""
+		}
+		lines := splitLines(string(body))
+		if n := lineAt(string(body), q0); n < len(lines) {
+			text = lines[n].text
+		}
+	}
+	if f := strings.Fields(text); len(f) > 0 {
+		return f[0]
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"plramos.win/9fans/acme"
+)
+
+// openInput opens the standard input given with -i: the selection or body
+// of a window, or a file. A window showing the file wins, it may hold
+// unsaved changes.
+func openInput(arg string) (io.ReadCloser, error) {
+	if fi, err := os.Stat(arg); err == nil && !fi.IsDir() && !windowNamed(arg) {
+		return os.Open(arg)
+	}
+	s, err := windowText(arg)
+	if err != nil {
+		return nil, err
+	}
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return io.NopCloser(strings.NewReader(s)), nil
+}
+
+func windowNamed(name string) bool {
+	wins, err := acme.Windows()
+	if err != nil {
+		return false
+	}
+	for _, w := range wins {
+		if w.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An interp is a program able to run scripts. Args is its command line:
+// a "{}" argument is replaced by the path of a file holding the script,
+// otherwise the script text is given as the last argument.
+type interp struct {
+	name string
+	exts []string
+	args []string
+}
+
+// defaultInterps are known without any configuration.
+var defaultInterps = []interp{
+	{"rc", []string{".rc"}, []string{"rc", "-c"}},
+	{"sh", []string{".sh"}, []string{"sh", "-c"}},
+	{"bash", []string{".bash"}, []string{"bash", "-c"}},
+	{"python3", []string{".py"}, []string{"python3", "-c"}},
+	{"python", nil, []string{"python3", "-c"}},
+	{"awk", []string{".awk"}, []string{"awk", "-f", "{}"}},
+	{"perl", []string{".pl"}, []string{"perl", "-e"}},
+	{"node", []string{".js"}, []string{"node", "-e"}},
+}
+
+// config is read from confPath. Each line is a keyword followed by its
+// arguments; # starts a comment at the start of a line or after a blank.
+// An interp line adds or replaces an
+// interpreter:
+//
+//	interp <name> <.ext,...|-> <command> [args...]
+//
+// for example
+//
+//	interp ruby .rb ruby -e
+//	interp lua .lua lua {}
+//
+// A cell line adds a marker separating the blocks run with -b, #%% is
+// always one. The marker is the rest of the line, blanks and # included:
+//
+//	cell <marker>
+//
+// for example
+//
+//	cell # %%
+//
+// The history line sets how many runs are kept in the history, 100 by
+// default, 0 not to keep any:
+//
+//	history <n>
+//
+// A confirm line makes runs of a whole body, with no selection, wait for
+// Go in a preview window, as with -n:
+//
+//	confirm
+type config struct {
+	interps []interp
+	markers []string
+	history int
+	confirm bool
+}
+
+// comment matches a comment ending a configuration line.
+var comment = regexp.MustCompile(`\s#.*$`)
+
+var confPath = filepath.Join(os.Getenv("HOME"), "lib", "run.conf")
+
+// loadConfig reads the configuration in path on top of the defaults.
+// A missing file is not an error.
+func loadConfig(path string) (*config, error) {
+	conf := &config{
+		interps: append([]interp(nil), defaultInterps...),
+		markers: []string{"#%%"},
+		history: 100,
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Fields(line)[0] == "cell" {
+			marker := strings.TrimSpace(strings.TrimPrefix(line, "cell"))
+			if marker == "" {
+				return nil, fmt.Errorf("%s:%d: usage: cell marker", path, n)
+			}
+			conf.markers = append(conf.markers, marker)
+			continue
+		}
+		fields := strings.Fields(comment.ReplaceAllString(line, ""))
+		switch fields[0] {
+		case "interp":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("%s:%d: usage: interp name exts command [args...]", path, n)
+			}
+			in := interp{name: fields[1], args: fields[3:]}
+			if fields[2] != "-" {
+				in.exts = strings.Split(fields[2], ",")
+			}
+			conf.addInterp(in)
+		case "history":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%s:%d: usage: history n", path, n)
+			}
+			if conf.history, err = strconv.Atoi(fields[1]); err != nil || conf.history < 0 {
+				return nil, fmt.Errorf("%s:%d: bad history size %s", path, n, fields[1])
+			}
+		case "confirm":
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("%s:%d: usage: confirm", path, n)
+			}
+			conf.confirm = true
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown keyword %s", path, n, fields[0])
+		}
+	}
+	return conf, scanner.Err()
+}
+
+func (conf *config) addInterp(in interp) {
+	for i := range conf.interps {
+		if conf.interps[i].name == in.name {
+			conf.interps[i] = in
+			return
+		}
+	}
+	conf.interps = append(conf.interps, in)
+}
+
+func (conf *config) byName(name string) (interp, bool) {
+	for _, in := range conf.interps {
+		if in.name == name {
+			return in, true
+		}
+	}
+	return interp{}, false
+}
+
+func (conf *config) byExt(ext string) (interp, bool) {
+	for _, in := range conf.interps {
+		for _, e := range in.exts {
+			if e == ext {
+				return in, true
+			}
+		}
+	}
+	return interp{}, false
+}
+
+// choose picks the interpreter for src, run from the file winName. In
+// order it is the one named by flag, the one on a #! line at the top of
+// src, the one for the extension of winName and rc.
+func (conf *config) choose(flag, src, winName string) interp {
+	if flag != "" {
+		return conf.lookup(strings.Fields(flag))
+	}
+	if line, _, _ := strings.Cut(src, "\n"); strings.HasPrefix(line, "#!") {
+		args := strings.Fields(strings.TrimPrefix(line, "#!"))
+		if len(args) > 1 && filepath.Base(args[0]) == "env" {
+			args = args[1:]
+			for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+				args = args[1:]
+			}
+		}
+		if len(args) > 0 {
+			return conf.lookup(args)
+		}
+	}
+	if in, ok := conf.byExt(filepath.Ext(winName)); ok && filepath.Ext(winName) != "" {
+		return in
+	}
+	in, _ := conf.byName("rc")
+	return in
+}
+
+// forLang returns the interpreter for the language of a fenced block,
+// either its name or its extension.
+func (conf *config) forLang(lang string) interp {
+	if in, ok := conf.byExt("." + lang); ok {
+		return in
+	}
+	return conf.lookup([]string{lang})
+}
+
+// lookup returns the interpreter named by args[0]. Unknown ones are run
+// as given with the script file as last argument.
+func (conf *config) lookup(args []string) interp {
+	name := filepath.Base(args[0])
+	if in, ok := conf.byName(name); ok && len(args) == 1 {
+		return in
+	}
+	return interp{name: name, args: append(args[:len(args):len(args)], "{}")}
+}
+
+func (in interp) String() string {
+	return strings.Join(in.args, " ")
+}
+
+// command returns the command running src with in and the file holding
+// src, if one was needed. The caller removes it.
+func (in interp) command(ctx context.Context, src string) (*exec.Cmd, string, error) {
+	args := append([]string(nil), in.args...)
+	file := ""
+	for i := range args {
+		if args[i] != "{}" {
+			continue
+		}
+		if file == "" {
+			var err error
+			if file, err = scriptFile(src, in.exts); err != nil {
+				return nil, "", err
+			}
+		}
+		args[i] = file
+	}
+	if file == "" {
+		args = append(args, src)
+	}
+	return exec.CommandContext(ctx, args[0], args[1:]...), file, nil
+}
+
+// scriptFile writes src to a temporary file named with the first of exts.
+func scriptFile(src string, exts []string) (string, error) {
+	ext := ""
+	if len(exts) > 0 {
+		ext = exts[0]
+	}
+	f, err := os.CreateTemp("", "run-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(src); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
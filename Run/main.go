@@ -1,56 +1,502 @@
+// Run executes the selection, or the whole body, of an acme window.
+//
+// The interpreter is the one given with -x, the one named in a #! line at
+// the top of the script, the one for the extension of the window file or,
+// failing those, rc. More interpreters can be set up in $HOME/lib/run.conf;
+// see config.
+//
+// The window is given by its ID or by a regexp matching its name and it is
+// $winid when none is given. If several windows match, a window listing
+// them is opened to pick one.
+//
+// With -b only the block around dot runs: the Markdown fenced code block,
+// whose language then picks the interpreter, the region between cell
+// markers such as #%% or else the paragraph.
+//
+// With -S the script runs in a session: an interpreter kept running for
+// the window, so variables, the current directory and definitions are kept
+// between runs. Rc, sh, bash and python have sessions. -restart and -kill
+// restart and end the sessions of the window; they also end when it is
+// deleted.
+//
+// The script runs in the directory of the window file, or the directory of a
+// directory window, with the acme context in its environment: $winid, $%
+// and $samfile name the window, $dot is the address of dot and $sel the
+// selected text.
+//
+// The script runs in a process group of its own. When it is stopped, by the
+// -t timeout, Kill or a SIGINT, SIGTERM or SIGHUP to Run, the whole group
+// gets SIGTERM and, a few seconds later, SIGKILL. Stopping a session run
+// ends its session, which starts afresh on the next run.
+//
+// With -R the block around dot runs and its output is written right below
+// it, between #+RESULTS: or, when it fails, #+ERROR: and #+END lines.
+// Running the block again replaces them and -strip deletes them all.
+//
+// With -w the body runs every time the window is put or its file changes,
+// killing the previous run if it is still going. The output goes to the
+// +Run window, cleared before each run.
+//
+// Placeholders {{name}} and $1 to $9 in the script are replaced by the
+// values given as name=value, or 1=value, arguments; a value @window is the
+// selection or body of that window. A form window asks for the missing
+// {{name}} ones, and the missing $N ones when any is given, to be filled
+// and sent with Go.
+//
+// Error messages of the interpreters pointing at lines of the script are
+// prefixed with the window name and the matching line of the window, so
+// they can be opened with button 3.
+//
+// With -r the script runs on a remote host through ssh. The host is either
+// an Ssh descriptor in $HOME/lib/coms/ssh or anything ssh knows, through
+// ssh_config for instance. The output and exit status are the remote ones.
+//
+// The script reads its standard input from the -i window, its selection
+// or else its body, or from the -i file. The input is read anew on every
+// run, so a data window and a filter script can sit side by side.
+//
+// Every run is recorded, with its output, in $HOME/lib/run.hist. -h opens a
+// window listing them where a run opens with button 3 and Again runs it
+// once more.
+//
+// With -n a <name>+Preview window shows the interpreter, directory,
+// environment additions and script of the run, which starts with Go in its
+// tag. The confirm option of the configuration does the same for every run
+// of a whole body. Runs in parallel are previewed together, in one window;
+// the runs of -w cannot be.
+//
+// Given several windows, or with -all-blocks every block of the windows,
+// the scripts run in parallel, -j at a time. A /Run/+all window shows the
+// status and duration of each and the file holding its output; Kill in
+// its tag stops them all.
+//
+// Snippets are scripts kept in $HOME/lib/run. -s runs one by name, as if
+// it were the body of a window in its place: the interpreter is chosen
+// from its #! line or extension and the context is that of the window
+// given, or $winid, if any. -l opens a window listing the snippets with
+// their first comment line; button 2 on a name runs it to a +Run window
+// and button 3 opens it.
+//
+// With -o the output goes to a <name>+Run window instead of the standard
+// output. Rerun in its tag runs the script again and Kill stops it. A
+// later Run with -o for the same window runs its script there, in place
+// of the one running, and Rerun then runs the new one.
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"plramos.win/9fans/acme"
 )
 
+var interpFlag = flag.String("x", "", "Interpreter to run the script with")
+var outFlag = flag.Bool("o", false, "Stream the output to a <name>+Run window")
+var blockFlag = flag.Bool("b", false, "Run the block around dot")
+var sessionFlag = flag.Bool("S", false, "Run in the session of the window")
+var restartFlag = flag.Bool("restart", false, "Restart the sessions of the window")
+var killFlag = flag.Bool("kill", false, "End the sessions of the window")
+var resultsFlag = flag.Bool("R", false, "Write the output of the block around dot below it")
+var stripFlag = flag.Bool("strip", false, "Delete the results written by -R")
+var watchFlag = flag.Bool("w", false, "Run the body again every time the window is put")
+var timeoutFlag = flag.Duration("t", 0, "Kill the script after this long")
+var remoteFlag = flag.String("r", "", "Run on this host, an ssh host or Ssh descriptor")
+var inputFlag = flag.String("i", "", "Read standard input from this window or file")
+var snippetFlag = flag.String("s", "", "Run this snippet of $HOME/lib/run")
+var listFlag = flag.Bool("l", false, "Open the list of snippets")
+var allBlocksFlag = flag.Bool("all-blocks", false, "Run every block of the windows")
+var jobsFlag = flag.Int("j", 4, "Run at most this many scripts at a time")
+var previewFlag = flag.Bool("n", false, "Preview the run and wait for Go")
+var historyFlag = flag.Bool("h", false, "Open the history of runs")
+var serveFlag = flag.Int("serve", 0, "Serve the session of a window (internal)")
+
 func usage() {
-	log.Println("Run cmd")
-	os.Exit(0)
+	fmt.Fprintf(os.Stderr, "usage: Run [-bhnoRSw] [-i window|file] [-r host] [-t timeout] [-x interp] [id|regexp] [name=value...]\n       Run [-bn] [-all-blocks] [-j n] [-i window|file] [-r host] [-t timeout] [-x interp] [id|regexp...] [name=value...]\n       Run [-noS] [-i window|file] [-r host] [-t timeout] [-x interp] -s snippet [id|regexp] [name=value...]\n       Run -h|-l\n       Run -restart|-kill|-strip [id|regexp]\n")
+	os.Exit(2)
 }
 
-func main() {
-	wins, err := acme.Windows()
-	if err != nil {
-		log.Fatal("Not running in acme")
+// A job is a script taken from a window and the interpreter to run it.
+type job struct {
+	name    string // window the script comes from
+	winid   int
+	src     string
+	in      interp
+	session bool   // run in the session of the window
+	block   *block // block run, with -b
+	line    int    // line of the window where src starts
+	keep    int    // runs kept in the history
+	remote  string // host to run on, with -r
+	input   string // window or file read as standard input, with -i
+	confirm bool   // wait for Go in a preview before running
+	timeout time.Duration
+	dir     string
+	env     []string // acme context added to the environment
+}
+
+// killGrace is how long a stopped script has to exit before it is killed.
+const killGrace = 3 * time.Second
+
+// run runs j until it finishes, ctx is done or its timeout expires,
+// and records it in the history.
+func (j *job) run(ctx context.Context, stdout, stderr io.Writer) error {
+	var out lockedBuffer
+	start := time.Now()
+	err := j.exec(ctx, io.MultiWriter(stdout, &out), io.MultiWriter(stderr, &out))
+	if j.keep > 0 {
+		if err := record(j, start, out.b.Bytes(), err); err != nil {
+			log.Printf("Cannot record the run: %v", err)
+		}
 	}
+	return err
+}
 
-	if len(os.Args) != 2 {
-		usage()
+func (j *job) exec(ctx context.Context, stdout, stderr io.Writer) error {
+	if j.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.timeout)
+		defer cancel()
+	}
+	if j.session {
+		if j.remote != "" {
+			return fmt.Errorf("sessions run only locally")
+		}
+		if j.input != "" {
+			return fmt.Errorf("sessions read no input")
+		}
+		errs := newErrMapper(stderr, j.name, j.line, "")
+		errs.lines = sessionErrLines
+		defer errs.Flush()
+		return runSession(ctx, j, stdout, errs)
+	}
+	var cmd *exec.Cmd
+	var file string
+	var err error
+	if j.remote != "" {
+		cmd, err = sshCommand(ctx, j.remote, j.in, j.src)
+	} else {
+		cmd, file, err = j.in.command(ctx, j.src)
 	}
+	if err != nil {
+		return err
+	}
+	if j.remote == "" {
+		cmd.Dir = j.dir
+	}
+	if file != "" {
+		defer os.Remove(file)
+	}
+	errs := newErrMapper(stderr, j.name, j.line, file)
+	defer errs.Flush()
+	setGroup(cmd)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, j.env...)
+	cmd.Stdin = os.Stdin
+	if j.input != "" {
+		in, err := openInput(j.input)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		cmd.Stdin = in
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = errs
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %v: %w", j.timeout, ctx.Err())
+	}
+	return err
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
 
-	winid := -1
-	found := 0
-	for _, w := range wins {
-		if strings.Contains(w.Name, os.Args[1]) {
-			winid = w.ID
-			found++
+	if *serveFlag != 0 {
+		serveSession(*serveFlag, *interpFlag)
+		return
+	}
+	if *historyFlag {
+		conf, err := loadConfig(confPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := history(conf); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *listFlag {
+		conf, err := loadConfig(confPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := listSnippets(conf); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	names, err := parseArgs(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(names) > 1 || *allBlocksFlag {
+		if *outFlag || *resultsFlag || *sessionFlag || *watchFlag || *snippetFlag != "" {
+			usage()
+		}
+		conf, err := loadConfig(confPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		var jobs []*job
+		for _, name := range names {
+			target, err := findWindow(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if *allBlocksFlag {
+				js, err := blockJobs(conf, target)
+				if err != nil {
+					log.Fatal(err)
+				}
+				jobs = append(jobs, js...)
+				continue
+			}
+			j, err := windowJob(conf, target)
+			if err != nil {
+				log.Fatal(err)
+			}
+			jobs = append(jobs, j)
+		}
+		confirm := *previewFlag
+		for _, j := range jobs {
+			confirm = confirm || j.confirm
+		}
+		if confirm {
+			ok, err := preview("/Run/+all", jobs...)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !ok {
+				return
+			}
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		if err := runAll(jobs, *jobsFlag, sigs); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *snippetFlag != "" {
+		if *blockFlag || *resultsFlag || *watchFlag {
+			usage()
+		}
+		conf, err := loadConfig(confPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		j, err := snippetJob(conf, *snippetFlag, strings.Join(names, ""))
+		if err != nil {
+			log.Fatal(err)
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		start(j, sigs)
+		return
+	}
+	target, err := findWindow(strings.Join(names, ""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *restartFlag || *killFlag {
+		op := "restart"
+		if *killFlag {
+			op = "kill"
+		}
+		if err := controlSessions(target.ID, op); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *stripFlag {
+		if err := stripResults(target.ID); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *resultsFlag {
+		*blockFlag = true
+	}
+	conf, err := loadConfig(confPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	if *watchFlag {
+		if *previewFlag {
+			log.Fatal("Cannot preview the runs of -w")
+		}
+		if err := watch(conf, target, sigs); err != nil {
+			log.Fatal(err)
 		}
+		return
 	}
-	if found == 0 {
-		log.Fatal("Window name does not match")
-	} else if found > 1 {
-		log.Fatal("Ambigous name")
+	j, err := windowJob(conf, target)
+	if err != nil {
+		log.Fatal(err)
 	}
+	start(j, sigs)
+}
 
-	w, _ := acme.Open(winid, nil)
-	src := w.Selection()
-	if src == "" {
-		buff, err := w.ReadAll("body")
+// start runs j with its output where the flags say: below its block, in a
+// +Run window or on the standard output. With -n, or when j has to be
+// confirmed, it waits for Go in a preview first.
+func start(j *job, sigs chan os.Signal) {
+	if *previewFlag || j.confirm {
+		ok, err := preview(j.name, j)
 		if err != nil {
-			log.Fatal("failed to read from acme window")
+			log.Fatal(err)
+		}
+		if !ok {
+			return
 		}
-		src = string(buff)
 	}
-	cmd := exec.Command("rc", "-c", src)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	switch {
+	case *resultsFlag:
+		var out bytes.Buffer
+		err := j.run(untilSignal(sigs), &out, &out)
+		if err := writeResults(j.winid, j.block, out.Bytes(), err); err != nil {
+			log.Fatal(err)
+		}
+	case *outFlag:
+		o, err := openOutWin(j.name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		o.serve(j, sigs)
+	default:
+		if err := j.run(untilSignal(sigs), os.Stdout, os.Stderr); err != nil {
+			log.Print(err)
+		}
+	}
+}
 
-	cmd.Run()
+// untilSignal returns a context cancelled by the first signal on sigs.
+func untilSignal(sigs <-chan os.Signal) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sigs
+		cancel()
+	}()
+	return ctx
+}
+
+// windowJob makes the job running the selection, block or body of the
+// window target, as the flags ask.
+func windowJob(conf *config, target acme.WinInfo) (*job, error) {
+	w, err := acme.Open(target.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer w.CloseFiles()
+	q0, q1, err := w.SelectionAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	buff, err := w.ReadAll("body")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from acme window")
+	}
+	body := string(buff)
+
+	var blk *block
+	var lang string
+	whole := false
+	sel := w.Selection()
+	src, line := sel, lineAt(body, q0)+1
+	if *watchFlag {
+		src = ""
+	}
+	if *blockFlag {
+		b, ok := blockAt(body, q0, conf.markers)
+		if !ok {
+			return nil, fmt.Errorf("No block at dot")
+		}
+		src, lang, blk, line = b.src, b.lang, &b, b.line
+	} else if src == "" {
+		src, line = body, 1
+		whole = true
+	}
+
+	if src, err = fillPlaceholders(src, target.Name); err != nil {
+		return nil, err
+	}
+	in := conf.choose(*interpFlag, src, target.Name)
+	if lang != "" && *interpFlag == "" {
+		in = conf.forLang(lang)
+	}
+	return &job{
+		name:    target.Name,
+		winid:   target.ID,
+		src:     src,
+		in:      in,
+		session: *sessionFlag,
+		block:   blk,
+		line:    line,
+		keep:    conf.history,
+		remote:  *remoteFlag,
+		input:   *inputFlag,
+		confirm: conf.confirm && whole && !*watchFlag,
+		timeout: *timeoutFlag,
+		dir:     windowDir(target.Name),
+		env:     contextEnv(target, q0, q1, sel),
+	}, nil
+}
+
+// contextEnv is the acme context of a script run for the window target
+// with dot at q0,q1 and sel selected.
+func contextEnv(target acme.WinInfo, q0, q1 int, sel string) []string {
+	return []string{
+		fmt.Sprintf("winid=%d", target.ID),
+		"%=" + target.Name,
+		"samfile=" + target.Name,
+		fmt.Sprintf("dot=#%d,#%d", q0, q1),
+		"sel=" + sel,
+	}
+}
+
+// windowDir returns the directory of the window named name: the directory
+// itself for directory windows. It is "" when there is no such directory.
+func windowDir(name string) string {
+	dir := name
+	if !strings.HasSuffix(name, "/") {
+		dir = filepath.Dir(name)
+	}
+	if !filepath.IsAbs(dir) {
+		return ""
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return ""
+	}
+	return dir
 }
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"plramos.win/9fans/acme"
+)
+
+// outWin is the <name>+Run window the output of a job is streamed to.
+// Each run starts with a header and ends with its exit status. Rerun runs
+// the job again and Kill stops it.
+//
+// One Run process owns the window: it reads its events and listens on a
+// unix socket named after it. Another Run for the same window hands its
+// job over the socket to the owner, which runs it in place of its own and
+// tells back how it went.
+type outWin struct {
+	*acme.Win
+	mu    sync.Mutex
+	id    int
+	owner bool     // whether this process reads the window events
+	conn  net.Conn // to the owner, when another process owns the window
+	busy  []int    // windows of the same name found busy before this one
+}
+
+// A wireJob is a job handed to the process owning an output window.
+type wireJob struct {
+	Name, Src, Remote, Input, Dir string
+	Winid, Line, Keep             int
+	Interp                        string
+	Exts, Args, Env               []string
+	Session                       bool
+	Timeout                       time.Duration
+}
+
+// An outReply tells the process handing a job how it went. Busy owners
+// take no jobs.
+type outReply struct {
+	Status string
+	Failed bool
+	Busy   bool
+}
+
+func (j *job) wire() wireJob {
+	return wireJob{
+		Name: j.name, Src: j.src, Remote: j.remote, Input: j.input, Dir: j.dir,
+		Winid: j.winid, Line: j.line, Keep: j.keep,
+		Interp: j.in.name, Exts: j.in.exts, Args: j.in.args, Env: j.env,
+		Session: j.session, Timeout: j.timeout,
+	}
+}
+
+func (w wireJob) job() *job {
+	return &job{
+		name: w.Name, src: w.Src, remote: w.Remote, input: w.Input, dir: w.Dir,
+		winid: w.Winid, line: w.Line, keep: w.Keep,
+		in:  interp{name: w.Interp, exts: w.Exts, args: w.Args},
+		env: w.Env, session: w.Session, timeout: w.Timeout,
+	}
+}
+
+func outPath(id int) string {
+	return filepath.Join(sessionDir(), fmt.Sprintf("%d.out", id))
+}
+
+// openOutWin opens the output window of the window name, except the busy
+// ones. An existing one is reused: connected to its owner or, if it has
+// none left, owned.
+func openOutWin(name string, busy ...int) (*outWin, error) {
+	name += "+Run"
+	if wins, err := acme.Windows(); err == nil {
+	Wins:
+		for _, wi := range wins {
+			if wi.Name != name {
+				continue
+			}
+			for _, id := range busy {
+				if wi.ID == id {
+					continue Wins
+				}
+			}
+			if conn, err := net.Dial("unix", outPath(wi.ID)); err == nil {
+				return &outWin{id: wi.ID, conn: conn, busy: busy}, nil
+			}
+			if w, err := acme.Open(wi.ID, nil); err == nil {
+				return &outWin{Win: w, id: wi.ID, owner: true}, nil
+			}
+		}
+	}
+	return newOutWin(name)
+}
+
+// newOutWin opens a new output window named name.
+func newOutWin(name string) (*outWin, error) {
+	w, err := acme.New()
+	if err != nil {
+		return nil, err
+	}
+	w.Name(name)
+	w.Fprintf("tag", "Rerun Kill")
+	return &outWin{Win: w, id: w.ID(), owner: true}, nil
+}
+
+func (o *outWin) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, err := o.Win.Write("body", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// run runs j writing its output to o and returns when it finishes.
+func (o *outWin) run(ctx context.Context, j *job) error {
+	where := j.name
+	if j.remote != "" {
+		where += " on " + j.remote
+	}
+	fmt.Fprintf(o, "\n== %s %s: %s ==\n", time.Now().Format("2006-01-02 15:04:05"), where, j.in)
+	err := j.run(ctx, o, o)
+	fmt.Fprintf(o, "--%s--\n", statusOf(ctx, err))
+	o.Ctl("clean")
+	return err
+}
+
+// A handoff is a job handed by another process and the connection to
+// answer it on.
+type handoff struct {
+	j    *job
+	conn net.Conn
+}
+
+// listen takes the jobs handed to the owner of o until the returned
+// function is called.
+func (o *outWin) listen() (<-chan handoff, func(), error) {
+	path := outPath(o.id)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, nil, err
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := make(chan handoff)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var w wireJob
+			if err := gob.NewDecoder(conn).Decode(&w); err != nil {
+				conn.Close()
+				continue
+			}
+			c <- handoff{w.job(), conn}
+		}
+	}()
+	return c, func() {
+		l.Close()
+		os.Remove(path)
+	}, nil
+}
+
+// handOff gives j to the owner of o and waits for it to finish. A signal
+// on sigs kills it. An owner that is busy with something else gets j run
+// in another window of the same name, or a new one.
+func (o *outWin) handOff(j *job, sigs <-chan os.Signal) error {
+	defer o.conn.Close()
+	if err := gob.NewEncoder(o.conn).Encode(j.wire()); err != nil {
+		return err
+	}
+	replies := make(chan outReply, 1)
+	go func() {
+		var r outReply
+		if err := gob.NewDecoder(o.conn).Decode(&r); err != nil {
+			r = outReply{Status: "KILLED", Failed: true}
+		}
+		replies <- r
+	}()
+	select {
+	case r := <-replies:
+		if r.Busy {
+			w, err := openOutWin(j.name, append(o.busy, o.id)...)
+			if err != nil {
+				return err
+			}
+			return w.serve(j, sigs)
+		}
+		if r.Failed {
+			return errors.New(r.Status)
+		}
+		return nil
+	case <-sigs:
+		// the owner kills the job when the connection closes
+		return errors.New("KILLED")
+	}
+}
+
+// serve runs j and then handles Rerun and Kill until the window is
+// deleted, running the jobs handed by other processes as they come. A
+// signal on sigs kills the running job too. Without owning the window it
+// hands j to its owner.
+func (o *outWin) serve(j *job, sigs <-chan os.Signal) error {
+	if !o.owner {
+		return o.handOff(j, sigs)
+	}
+	jobs, unlisten, err := o.listen()
+	if err != nil {
+		return err
+	}
+	defer unlisten()
+
+	var (
+		cancel   context.CancelFunc
+		running  chan bool
+		finished <-chan bool // running, until the end of the run is seen
+		client   net.Conn    // of the handed job running, if any
+		gone     chan bool   // closed when client goes away
+	)
+	start := func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		running = make(chan bool)
+		finished = running
+		go func(done chan bool) {
+			err = o.run(ctx, j)
+			close(done)
+		}(running)
+	}
+	stop := func() {
+		cancel()
+		<-running
+	}
+	// answer tells the client of the job that ended how it went.
+	answer := func() {
+		if client == nil {
+			return
+		}
+		r := outReply{Status: "EXIT STATUS 0"}
+		if err != nil {
+			r = outReply{Status: err.Error(), Failed: true}
+		}
+		gob.NewEncoder(client).Encode(r)
+		client.Close()
+		client, gone = nil, nil
+	}
+
+	start()
+	events := o.EventChan()
+	for {
+		var e *acme.Event
+		select {
+		case <-sigs:
+			cancel()
+			continue
+		case <-finished:
+			finished = nil
+			answer()
+			continue
+		case <-gone:
+			gone = nil
+			cancel()
+			continue
+		case h := <-jobs:
+			stop()
+			answer()
+			j, client, gone = h.j, h.conn, make(chan bool)
+			go func(conn net.Conn, gone chan bool) {
+				io.Copy(io.Discard, conn)
+				close(gone)
+			}(client, gone)
+			start()
+			continue
+		case ev, ok := <-events:
+			if !ok {
+				stop()
+				answer()
+				return err
+			}
+			e = ev
+		}
+		if e.C2 != 'x' && e.C2 != 'X' {
+			o.WriteEvent(e)
+			continue
+		}
+		switch string(e.Text) {
+		case "Rerun":
+			stop()
+			answer()
+			start()
+		case "Kill":
+			cancel()
+		case "Del":
+			stop()
+			answer()
+			o.WriteEvent(e)
+		default:
+			o.WriteEvent(e)
+		}
+	}
+}
+
+// refuse answers the jobs handed to a busy owner.
+func refuse(jobs <-chan handoff) {
+	for h := range jobs {
+		gob.NewEncoder(h.conn).Encode(outReply{Busy: true})
+		h.conn.Close()
+	}
+}
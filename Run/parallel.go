@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"plramos.win/9fans/acme"
+)
+
+// allBlocks returns the blocks of body, in order. When it has fenced
+// blocks only those are returned, the text around them is prose.
+func allBlocks(body string, markers []string) []block {
+	var blocks, fenced []block
+	q := 0
+	for _, l := range splitLines(body) {
+		if l.q0 < q {
+			continue
+		}
+		b, ok := blockAt(body, l.q0, markers)
+		if !ok || b.q0 < q || strings.TrimSpace(b.src) == "" {
+			continue
+		}
+		blocks = append(blocks, b)
+		if b.fenced {
+			fenced = append(fenced, b)
+		}
+		q = b.q1
+	}
+	if len(fenced) > 0 {
+		return fenced
+	}
+	return blocks
+}
+
+// blockJobs makes a job for every block of the window target.
+func blockJobs(conf *config, target acme.WinInfo) ([]*job, error) {
+	w, err := acme.Open(target.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer w.CloseFiles()
+	buff, err := w.ReadAll("body")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from acme window")
+	}
+
+	var jobs []*job
+	for _, b := range allBlocks(string(buff), conf.markers) {
+		b := b
+		src, err := fillPlaceholders(b.src, target.Name)
+		if err != nil {
+			return nil, err
+		}
+		in := conf.choose(*interpFlag, src, target.Name)
+		if b.lang != "" && *interpFlag == "" {
+			in = conf.forLang(b.lang)
+		}
+		jobs = append(jobs, &job{
+			name:    target.Name,
+			winid:   target.ID,
+			src:     src,
+			in:      in,
+			block:   &b,
+			line:    b.line,
+			keep:    conf.history,
+			remote:  *remoteFlag,
+			input:   *inputFlag,
+			timeout: *timeoutFlag,
+			dir:     windowDir(target.Name),
+			env:     contextEnv(target, b.q0, b.q1, ""),
+		})
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%s: no blocks", target.Name)
+	}
+	return jobs, nil
+}
+
+// runAll runs jobs, at most limit at a time, and shows how they do in a
+// /Run/+all window: the status and duration of each and the file holding
+// its output. Kill stops them all.
+func runAll(jobs []*job, limit int, sigs <-chan os.Signal) error {
+	if err := os.MkdirAll(sessionDir(), 0700); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(sessionDir(), "all.")
+	if err != nil {
+		return err
+	}
+	w, err := acme.New()
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+	w.Name("/Run/+all")
+	w.Fprintf("tag", "Kill")
+
+	type result struct {
+		status string
+		took   time.Duration
+		out    string
+	}
+	var mu sync.Mutex
+	results := make([]result, len(jobs))
+	for i := range jobs {
+		results[i] = result{status: "waiting", out: filepath.Join(dir, fmt.Sprintf("%d.out", i+1))}
+	}
+	show := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Clear()
+		for i, j := range jobs {
+			name := j.name
+			if j.block != nil {
+				name = fmt.Sprintf("%s:%d", j.name, j.line)
+			}
+			r := results[i]
+			w.Fprintf("body", "%s\t%s\t%v\t%s\n", name, r.status, r.took.Round(time.Millisecond), r.out)
+		}
+		w.Ctl("clean")
+	}
+	set := func(i int, status string, took time.Duration) {
+		mu.Lock()
+		results[i].status, results[i].took = status, took
+		mu.Unlock()
+		show()
+	}
+
+	if limit < 1 {
+		limit = 1
+	}
+	ctx, cancel := context.WithCancel(untilSignal(sigs))
+	defer cancel()
+	slots := make(chan bool, limit)
+	var wg sync.WaitGroup
+	show()
+	for i, j := range jobs {
+		wg.Add(1)
+		go func(i int, j *job) {
+			defer wg.Done()
+			slots <- true
+			defer func() { <-slots }()
+			if ctx.Err() != nil {
+				set(i, "KILLED", 0)
+				return
+			}
+			set(i, "running", 0)
+			f, err := os.Create(results[i].out)
+			if err != nil {
+				set(i, fmt.Sprintf("FAILED: %v", err), 0)
+				return
+			}
+			start := time.Now()
+			err = j.run(ctx, f, f)
+			f.Close()
+			set(i, statusOf(ctx, err), time.Since(start))
+		}(i, j)
+	}
+
+	for e := range w.EventChan() {
+		switch {
+		case e.C2 == 'x' && string(e.Text) == "Kill":
+			cancel()
+		default:
+			w.WriteEvent(e)
+		}
+	}
+	cancel()
+	wg.Wait()
+	return nil
+}
+
+// statusOf describes how a job run with ctx and ending with err went.
+func statusOf(ctx context.Context, err error) string {
+	var exitErr interface{ ExitCode() int }
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "TIMED OUT"
+	case ctx.Err() != nil:
+		return "KILLED"
+	case err == nil:
+		return "EXIT STATUS 0"
+	case errors.As(err, &exitErr):
+		return fmt.Sprintf("EXIT STATUS %d", exitErr.ExitCode())
+	default:
+		return fmt.Sprintf("FAILED: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "plramos.win/9fans/acme"
+
+// preview shows what running jobs would do in a name+Preview window: for
+// each, the interpreter, directory and environment additions, then the
+// script. It reports whether Go was executed in it, rather than Del.
+func preview(name string, jobs ...*job) (bool, error) {
+	w, err := acme.New()
+	if err != nil {
+		return false, err
+	}
+	defer w.CloseFiles()
+	w.Name(name + "+Preview")
+	w.Fprintf("tag", "Go")
+
+	for i, j := range jobs {
+		if i > 0 {
+			w.Fprintf("body", "\n")
+		}
+		if len(jobs) > 1 {
+			w.Fprintf("body", "== %s:%d ==\n", j.name, j.line)
+		}
+		w.Fprintf("body", "interp\t%s\n", j.in)
+		dir := j.dir
+		if dir == "" {
+			dir = "."
+		}
+		w.Fprintf("body", "dir\t%s\n", dir)
+		if j.session {
+			w.Fprintf("body", "session\t%d\n", j.winid)
+		}
+		if j.remote != "" {
+			w.Fprintf("body", "remote\t%s\n", j.remote)
+		}
+		if j.input != "" {
+			w.Fprintf("body", "input\t%s\n", j.input)
+		}
+		for _, kv := range j.env {
+			w.Fprintf("body", "env\t%q\n", kv)
+		}
+		w.Fprintf("body", "\n%s", j.src)
+	}
+	w.Ctl("clean")
+	w.Addr("0,0")
+	w.Ctl("dot=addr")
+
+	for e := range w.EventChan() {
+		if e.C2 == 'x' && string(e.Text) == "Go" {
+			w.Ctl("delete")
+			return true, nil
+		}
+		w.WriteEvent(e)
+	}
+	return false, nil
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setGroup makes cancelling cmd kill it. Without process groups its
+// children are left alone.
+func setGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = killGrace
+}
+
+// killGroup kills the process pgid.
+func killGroup(pgid int) error {
+	p, err := os.FindProcess(pgid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
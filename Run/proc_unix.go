@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setGroup starts cmd in a process group of its own. Cancelling it sends
+// SIGTERM to the whole group and, if it is still there after killGrace,
+// SIGKILL.
+func setGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killGroup(cmd.Process.Pid)
+	}
+	cmd.WaitDelay = killGrace + time.Second
+}
+
+// killGroup terminates the process group pgid: SIGTERM and, if it is still
+// there after killGrace, SIGKILL. It returns once the group is gone or has
+// been sent SIGKILL, so the caller may exit right after.
+func killGroup(pgid int) error {
+	err := syscall.Kill(-pgid, syscall.SIGTERM)
+	for deadline := time.Now().Add(killGrace); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if syscall.Kill(-pgid, 0) != nil {
+			return err
+		}
+	}
+	syscall.Kill(-pgid, syscall.SIGKILL)
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sshDir holds the descriptors of the Ssh command. A host named after one
+// of them is reached with its host, user and key.
+var sshDir = filepath.Join(os.Getenv("HOME"), "lib", "coms", "ssh")
+
+// sshServer is the part of an Ssh descriptor needed to connect.
+type sshServer struct {
+	host, user, key string
+	password        bool
+}
+
+// readDescriptor reads the Ssh descriptor name: a text, a --end-- line and
+// the settings.
+func readDescriptor(name string) (sshServer, error) {
+	var s sshServer
+	f, err := os.Open(filepath.Join(sshDir, name))
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "--end--" {
+			break
+		}
+	}
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) == 0 {
+			continue
+		}
+		switch {
+		case f[0] == "password":
+			s.password = true
+		case len(f) < 2:
+		case f[0] == "host":
+			s.host = f[1]
+		case f[0] == "user":
+			s.user = f[1]
+		case f[0] == "key":
+			s.key = f[1]
+		}
+	}
+	if s.host == "" {
+		return s, fmt.Errorf("%s: no host in descriptor", name)
+	}
+	return s, scanner.Err()
+}
+
+// sshCommand returns the command running src with in on host. Host is an
+// Ssh descriptor name or else anything ssh takes, ssh_config hosts
+// included. The script travels in the command line, so the standard input
+// stays free.
+func sshCommand(ctx context.Context, host string, in interp, src string) (*exec.Cmd, error) {
+	var args []string
+	var env []string
+	if s, err := readDescriptor(host); err == nil {
+		host = s.host
+		if s.user != "" {
+			args = append(args, "-l", s.user)
+		}
+		if s.key != "" && !s.password {
+			args = append(args, "-i", s.key)
+		}
+		if s.password {
+			if env, err = askpassEnv(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	args = append(args, "-T", host, "--", remoteCommand(in, src))
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Env = env
+	return cmd, nil
+}
+
+// remoteCommand is the shell command running src with in. Interpreters
+// reading the script from a file get a temporary one.
+func remoteCommand(in interp, src string) string {
+	var args []string
+	file := false
+	for _, a := range in.args {
+		if a == "{}" {
+			args = append(args, `"$f"`)
+			file = true
+			continue
+		}
+		args = append(args, shQuote(a))
+	}
+	if !file {
+		return strings.Join(append(args, shQuote(src)), " ")
+	}
+	return fmt.Sprintf(`f=$(mktemp) || exit 1; printf '%%s' %s >"$f"; %s; s=$?; rm -f "$f"; exit $s`,
+		shQuote(src), strings.Join(args, " "))
+}
+
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// askpassEnv is the environment for ssh to ask a password without a
+// terminal: through $SSH_ASKPASS, or ssh-askpass when it is not set. It
+// fails when there is no such program or no display to show it on.
+func askpassEnv() ([]string, error) {
+	askpass := os.Getenv("SSH_ASKPASS")
+	if askpass == "" {
+		askpass = "ssh-askpass"
+	}
+	if _, err := exec.LookPath(askpass); err != nil {
+		return nil, fmt.Errorf("cannot ask for the password: %v", err)
+	}
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil, fmt.Errorf("cannot ask for the password: no display for %s", askpass)
+	}
+	return append(os.Environ(), "SSH_ASKPASS="+askpass, "SSH_ASKPASS_REQUIRE=force"), nil
+}
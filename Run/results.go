@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"plramos.win/9fans/acme"
+)
+
+// Results of a block run with -R are written right below it, between a
+// header line and an end line. The header says whether the block failed.
+const (
+	resultsHeader = "#+RESULTS:"
+	errorHeader   = "#+ERROR:"
+	resultsEnd    = "#+END"
+)
+
+func isResults(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, resultsHeader) || strings.HasPrefix(line, errorHeader)
+}
+
+// resultsAfter returns the rune offset where the results starting at the
+// line i end, or false when there are none.
+func resultsAfter(lines []bodyLine, i int) (int, bool) {
+	if i >= len(lines) || !isResults(lines[i].text) {
+		return 0, false
+	}
+	for ; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i].text) == resultsEnd {
+			return lines[i].q1, true
+		}
+	}
+	return 0, false
+}
+
+// formatResults returns out in results markers, marked as an error when
+// err is not nil.
+func formatResults(out []byte, err error) string {
+	var b strings.Builder
+	if err != nil {
+		fmt.Fprintf(&b, "%s %v\n", errorHeader, err)
+	} else {
+		fmt.Fprintf(&b, "%s\n", resultsHeader)
+	}
+	b.Write(out)
+	if len(out) > 0 && !bytes.HasSuffix(out, []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(&b, "%s\n", resultsEnd)
+	return b.String()
+}
+
+// writeResults writes the output of the block b of window id below it,
+// replacing the results already there. The block is looked for anew, as
+// the body may have been edited during the run; if it is gone nothing is
+// written.
+func writeResults(id int, b *block, out []byte, err error) error {
+	w, werr := acme.Open(id, nil)
+	if werr != nil {
+		return werr
+	}
+	defer w.CloseFiles()
+	body, werr := w.ReadAll("body")
+	if werr != nil {
+		return werr
+	}
+	_, end, ok := b.find(string(body))
+	if !ok {
+		return fmt.Errorf("block at line %d changed while running, results not written", b.line)
+	}
+	lines := splitLines(string(body))
+	q0, q1 := end, end
+	for i, l := range lines {
+		if l.q0 == end {
+			if end, ok := resultsAfter(lines, i); ok {
+				q1 = end
+			}
+			break
+		}
+	}
+	text := formatResults(out, err)
+	if len(lines) > 0 && q0 == lines[len(lines)-1].q1 && !strings.HasSuffix(string(body), "\n") {
+		text = "\n" + text
+	}
+	if werr := w.Addr("#%d,#%d", q0, q1); werr != nil {
+		return werr
+	}
+	_, werr = w.Write("data", []byte(text))
+	return werr
+}
+
+// stripResults deletes every result from the body of window id.
+func stripResults(id int) error {
+	w, err := acme.Open(id, nil)
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+	body, err := w.ReadAll("body")
+	if err != nil {
+		return err
+	}
+	lines := splitLines(string(body))
+	type span struct{ q0, q1 int }
+	var spans []span
+	for i := 0; i < len(lines); i++ {
+		end, ok := resultsAfter(lines, i)
+		if !ok {
+			continue
+		}
+		spans = append(spans, span{lines[i].q0, end})
+		for i+1 < len(lines) && lines[i+1].q0 < end {
+			i++
+		}
+	}
+	// from the bottom up, so the offsets stay valid
+	for i := len(spans) - 1; i >= 0; i-- {
+		if err := w.Addr("#%d,#%d", spans[i].q0, spans[i].q1); err != nil {
+			return err
+		}
+		if _, err := w.Write("data", nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"plramos.win/9fans/acme"
+)
+
+// Sessions keep one interpreter running per window and interpreter, so
+// variables, the current directory and definitions survive between runs.
+// A session is served by a Run process started with -serve, listening on
+// a unix socket named after the window and the interpreter. It ends with
+// its window, or with -kill.
+
+// A repl runs an interpreter reading scripts from its standard input.
+// Wrap returns what to write to set the environment additions env, run
+// src and then print sentinel, followed by the exit status, on the
+// standard output and sentinel alone on the standard error.
+type repl struct {
+	args []string
+	wrap func(src string, env []string, sentinel string) string
+}
+
+var repls = map[string]repl{
+	"rc":      {[]string{"rc"}, rcWrap},
+	"sh":      {[]string{"sh"}, shWrap},
+	"bash":    {[]string{"bash"}, shWrap},
+	"python3": {[]string{"python3", "-u", "-c", pyDriver}, pyWrap},
+	"python":  {[]string{"python3", "-u", "-c", pyDriver}, pyWrap},
+}
+
+// shellName matches the variable names the shells can set; the others,
+// like %, keep the value they had when the session started.
+var shellName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// assign returns the assignments of the variables of env a shell can set,
+// one per line, made by set.
+func assign(env []string, set func(name, value string) string) string {
+	var b strings.Builder
+	for _, kv := range env {
+		if name, value, ok := strings.Cut(kv, "="); ok && shellName.MatchString(name) {
+			b.WriteString(set(name, value) + "\n")
+		}
+	}
+	return b.String()
+}
+
+func rcWrap(src string, env []string, sentinel string) string {
+	set := func(name, value string) string {
+		return name + "='" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return fmt.Sprintf("%s%s\necho %s $status; echo %s >[1=2]\n", assign(env, set), src, sentinel, sentinel)
+}
+
+func shWrap(src string, env []string, sentinel string) string {
+	set := func(name, value string) string {
+		return "export " + name + "=" + shQuote(value)
+	}
+	return fmt.Sprintf("%s%s\necho %s $?; echo %s >&2\n", assign(env, set), src, sentinel, sentinel)
+}
+
+// pyDriver reads the environment additions as a JSON object, the length
+// of a script, the script and the sentinel, and runs the script keeping
+// its globals.
+const pyDriver = `import json, os, sys, traceback
+g = {"__name__": "__main__"}
+while True:
+    env = sys.stdin.readline()
+    if not env:
+        break
+    os.environ.update(json.loads(env))
+    n = sys.stdin.readline()
+    src = sys.stdin.read(int(n))
+    sentinel = sys.stdin.readline().strip()
+    status = 0
+    try:
+        exec(compile(src, "<run>", "exec"), g)
+    except SystemExit as e:
+        status = e.code if isinstance(e.code, int) else 1
+    except BaseException:
+        traceback.print_exc()
+        status = 1
+    print(sentinel, status, flush=True)
+    print(sentinel, file=sys.stderr, flush=True)
+`
+
+func pyWrap(src string, env []string, sentinel string) string {
+	vars := make(map[string]string)
+	for _, kv := range env {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			vars[name] = value
+		}
+	}
+	b, _ := json.Marshal(vars)
+	return fmt.Sprintf("%s\n%d\n%s%s\n", b, utf8.RuneCountInString(src), src, sentinel)
+}
+
+type sessionReq struct {
+	Op      string // run, restart or kill
+	Src     string
+	Env     []string      // the acme context of the run
+	Timeout time.Duration // after which the session is killed, if not 0
+}
+
+type sessionMsg struct {
+	Out      []byte
+	Stderr   bool
+	Done     bool
+	Status   int
+	Err      string
+	TimedOut bool
+}
+
+// exitStatus is the error of a script ending with a non zero status.
+type exitStatus int
+
+func (e exitStatus) Error() string { return "exit status " + strconv.Itoa(int(e)) }
+func (e exitStatus) ExitCode() int { return int(e) }
+
+func sessionDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("run.%d", os.Getuid()))
+}
+
+func sessionPath(winid int, name string) string {
+	return filepath.Join(sessionDir(), fmt.Sprintf("%d.%s", winid, name))
+}
+
+// runSession runs j in the session of its window, starting it if needed.
+// A new session starts in the directory and with the environment of j;
+// every run then sets the acme context of its own job.
+func runSession(ctx context.Context, j *job, stdout, stderr io.Writer) error {
+	if _, ok := repls[j.in.name]; !ok {
+		return fmt.Errorf("%s cannot run in a session", j.in.name)
+	}
+	conn, err := dialSession(j)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	req := sessionReq{Op: "run", Src: j.src, Env: j.env, Timeout: j.timeout}
+	if err := gob.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(conn)
+	for {
+		var m sessionMsg
+		if err := dec.Decode(&m); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("session: %w", err)
+		}
+		if m.Stderr {
+			stderr.Write(m.Out)
+		} else {
+			stdout.Write(m.Out)
+		}
+		switch {
+		case m.TimedOut:
+			return fmt.Errorf("timed out after %v: %w", req.Timeout, context.DeadlineExceeded)
+		case m.Err != "":
+			return errors.New(m.Err)
+		case m.Done && m.Status != 0:
+			return exitStatus(m.Status)
+		case m.Done:
+			return nil
+		}
+	}
+}
+
+// dialSession connects to the session of the window and interpreter of
+// j, starting a server when there is none.
+func dialSession(j *job) (net.Conn, error) {
+	path := sessionPath(j.winid, j.in.name)
+	if conn, err := net.Dial("unix", path); err == nil {
+		return conn, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, "-serve", strconv.Itoa(j.winid), "-x", j.in.name)
+	cmd.Dir = j.dir
+	cmd.Env = append(os.Environ(), j.env...)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	cmd.Process.Release()
+	for i := 0; i < 50; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if conn, err := net.Dial("unix", path); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("session for window %d did not start", j.winid)
+}
+
+// controlSessions sends op, restart or kill, to the sessions of winid.
+func controlSessions(winid int, op string) error {
+	paths, _ := filepath.Glob(filepath.Join(sessionDir(), fmt.Sprintf("%d.*", winid)))
+	if len(paths) == 0 {
+		return fmt.Errorf("no session for window %d", winid)
+	}
+	for _, path := range paths {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		gob.NewEncoder(conn).Encode(sessionReq{Op: op})
+		var m sessionMsg
+		gob.NewDecoder(conn).Decode(&m)
+		conn.Close()
+		if m.Err != "" {
+			return errors.New(m.Err)
+		}
+	}
+	return nil
+}
+
+// A session is an interpreter process fed through a repl. Runs take
+// turns; kill and restart do not wait for them.
+type session struct {
+	mu       sync.Mutex
+	repl     repl
+	sentinel string
+	turn     chan bool // held by the run in progress
+	pgid     atomic.Int64
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	out      chan sessionMsg // lines of output, closed when the process ends
+}
+
+// serveSession serves the session of window winid running the
+// interpreter name until the window is deleted or the session killed.
+func serveSession(winid int, name string) {
+	r, ok := repls[name]
+	if !ok {
+		log.Fatalf("%s cannot run in a session", name)
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	s := &session{repl: r, sentinel: fmt.Sprintf("--run-%x--", b), turn: make(chan bool, 1)}
+
+	path := sessionPath(winid, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Fatal(err)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	go func() {
+		lr, err := acme.Log()
+		if err != nil {
+			return
+		}
+		defer lr.Close()
+		for {
+			ev, err := lr.Read()
+			if err != nil {
+				return
+			}
+			if ev.ID == winid && ev.Op == "del" {
+				l.Close()
+				s.kill()
+				return
+			}
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			break
+		}
+		go func() {
+			if s.handle(conn) {
+				l.Close()
+			}
+		}()
+	}
+	s.mu.Lock()
+	s.stop()
+	s.mu.Unlock()
+}
+
+// handle serves a request on conn. It reports whether the session was
+// killed.
+func (s *session) handle(conn net.Conn) bool {
+	defer conn.Close()
+	var req sessionReq
+	if err := gob.NewDecoder(conn).Decode(&req); err != nil {
+		return false
+	}
+	enc := gob.NewEncoder(conn)
+	switch req.Op {
+	case "restart":
+		s.kill()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.stop()
+		if err := s.start(); err != nil {
+			enc.Encode(sessionMsg{Err: err.Error()})
+			return false
+		}
+		enc.Encode(sessionMsg{Done: true})
+		return false
+	case "kill":
+		s.kill()
+		enc.Encode(sessionMsg{Done: true})
+		return true
+	}
+
+	// The client sends nothing more: a read ends when it goes away.
+	gone := make(chan bool)
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	select {
+	case s.turn <- true:
+	case <-gone:
+		return false
+	}
+	defer func() { <-s.turn }()
+
+	s.mu.Lock()
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			s.mu.Unlock()
+			enc.Encode(sessionMsg{Err: err.Error()})
+			return false
+		}
+	}
+	out := s.out
+	_, err := io.WriteString(s.stdin, s.repl.wrap(req.Src, req.Env, s.sentinel))
+	if err != nil {
+		s.stop()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		enc.Encode(sessionMsg{Err: "session ended: " + err.Error()})
+		return false
+	}
+
+	var timeout <-chan time.Time
+	if req.Timeout > 0 {
+		t := time.NewTimer(req.Timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	status, sawOut, sawErr := 0, false, false
+	for !sawOut || !sawErr {
+		var m sessionMsg
+		var ok bool
+		select {
+		case m, ok = <-out:
+		case <-gone:
+			// a hung script is killed with its session
+			s.kill()
+			return false
+		case <-timeout:
+			s.kill()
+			enc.Encode(sessionMsg{TimedOut: true})
+			return false
+		}
+		if !ok {
+			enc.Encode(sessionMsg{Err: "session ended"})
+			return false
+		}
+		if i := strings.Index(string(m.Out), s.sentinel); i >= 0 {
+			if m.Stderr {
+				sawErr = true
+			} else {
+				sawOut = true
+				status, _ = strconv.Atoi(strings.TrimSpace(string(m.Out[i+len(s.sentinel):])))
+			}
+			m.Out = m.Out[:i]
+			if len(m.Out) == 0 {
+				continue
+			}
+		}
+		if err := enc.Encode(m); err != nil {
+			s.kill()
+			return false
+		}
+	}
+	enc.Encode(sessionMsg{Done: true, Status: status})
+	return false
+}
+
+// start starts the interpreter. It is called with s.mu held.
+func (s *session) start() error {
+	cmd := exec.Command(s.repl.args[0], s.repl.args[1:]...)
+	setGroup(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	out := make(chan sessionMsg, 64)
+	var wg sync.WaitGroup
+	read := func(r io.Reader, isErr bool) {
+		defer wg.Done()
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				out <- sessionMsg{Out: line, Stderr: isErr}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go read(stdout, false)
+	go read(stderr, true)
+	go func() {
+		wg.Wait()
+		cmd.Wait()
+		close(out)
+		s.mu.Lock()
+		if s.cmd == cmd {
+			s.stdin.Close()
+			s.cmd, s.stdin, s.out = nil, nil, nil
+			s.pgid.Store(0)
+		}
+		s.mu.Unlock()
+	}()
+	s.cmd, s.stdin, s.out = cmd, stdin, out
+	s.pgid.Store(int64(cmd.Process.Pid))
+	return nil
+}
+
+// stop ends the interpreter. It is called with s.mu held.
+func (s *session) stop() {
+	if s.cmd == nil {
+		return
+	}
+	s.stdin.Close()
+	go func(out chan sessionMsg) {
+		for range out {
+		}
+	}(s.out)
+	killGroup(s.cmd.Process.Pid)
+	s.cmd, s.stdin, s.out = nil, nil, nil
+	s.pgid.Store(0)
+}
+
+// kill ends the process group of the interpreter without waiting for
+// s.mu; the process ending clears the session.
+func (s *session) kill() {
+	if pgid := s.pgid.Load(); pgid != 0 {
+		killGroup(int(pgid))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"plramos.win/9fans/acme"
+)
+
+// snippetDir holds the snippets: scripts run by name with -s.
+var snippetDir = filepath.Join(os.Getenv("HOME"), "lib", "run")
+
+// snippetJob makes the job running the snippet name. Its context is the
+// window ctxName, or $winid, when there is one.
+func snippetJob(conf *config, name, ctxName string) (*job, error) {
+	path := filepath.Join(snippetDir, name)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	src := string(b)
+	if src, err = fillPlaceholders(src, path); err != nil {
+		return nil, err
+	}
+	j := &job{
+		name:    path,
+		src:     src,
+		in:      conf.choose(*interpFlag, src, path),
+		session: *sessionFlag,
+		line:    1,
+		keep:    conf.history,
+		remote:  *remoteFlag,
+		input:   *inputFlag,
+		timeout: *timeoutFlag,
+	}
+	target, err := findWindow(ctxName)
+	switch {
+	case err == nil:
+		w, err := acme.Open(target.ID, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer w.CloseFiles()
+		q0, q1, err := w.SelectionAddr()
+		if err != nil {
+			return nil, err
+		}
+		j.winid = target.ID
+		j.dir = windowDir(target.Name)
+		j.env = contextEnv(target, q0, q1, w.Selection())
+	case ctxName != "":
+		return nil, err
+	case j.session:
+		return nil, fmt.Errorf("Sessions need a window")
+	}
+	return j, nil
+}
+
+// snippets lists the snippets under snippetDir, dot files left out.
+func snippets() []string {
+	var names []string
+	filepath.WalkDir(snippetDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != snippetDir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			name, _ := filepath.Rel(snippetDir, path)
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names
+}
+
+// describe returns the first comment line of the snippet name, past any
+// #! line, without its comment marker.
+func describe(name string) string {
+	b, err := os.ReadFile(filepath.Join(snippetDir, name))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#!") {
+			continue
+		}
+		for _, marker := range []string{"#", "//", "--", "%", ";"} {
+			if strings.HasPrefix(line, marker) {
+				return strings.TrimSpace(strings.TrimLeft(line, marker))
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// listSnippets opens a window listing the snippets with their description.
+// Button 2 on a name runs it, to a +Run window, and button 3 opens it.
+func listSnippets(conf *config) error {
+	w, err := acme.New()
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+	winName := "/Run/+snippets"
+	w.Name(winName)
+	w.Fprintf("tag", "Get")
+
+	var names []string
+	list := func() {
+		names = snippets()
+		w.Clear()
+		for _, name := range names {
+			w.Fprintf("body", "%s\t%s\n", name, describe(name))
+		}
+		w.Ctl("clean")
+		w.Addr("0,0")
+		w.Ctl("dot=addr")
+	}
+	isSnippet := func(s string) bool {
+		for _, name := range names {
+			if name == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the +Run windows opened are served until deleted, even after w
+	var wg sync.WaitGroup
+	list()
+	for e := range w.EventChan() {
+		switch {
+		case e.C2 == 'x' && string(e.Text) == "Get":
+			list()
+		case e.C2 == 'X' && isSnippet(string(e.Text)):
+			j, err := snippetJob(conf, string(e.Text), "")
+			if err != nil {
+				acme.Errf(winName, "%v", err)
+				continue
+			}
+			o, err := openOutWin(j.name)
+			if err != nil {
+				acme.Errf(winName, "%v", err)
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				o.serve(j, nil)
+			}()
+		case e.C2 == 'L' && isSnippet(string(e.Text)):
+			fw, err := acme.New()
+			if err != nil {
+				continue
+			}
+			fw.Name(filepath.Join(snippetDir, string(e.Text)))
+			fw.Ctl("get")
+			fw.CloseFiles()
+		default:
+			w.WriteEvent(e)
+		}
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"plramos.win/9fans/acme"
+)
+
+const pickerName = "/Run/+pick"
+
+// findWindow returns the window named by arg. It is either a window ID or
+// a regexp matched against the window names; without arg it is $winid.
+// When several windows match, and none is named arg exactly, a picker
+// window lists them to choose one.
+func findWindow(arg string) (acme.WinInfo, error) {
+	wins, err := acme.Windows()
+	if err != nil {
+		return acme.WinInfo{}, fmt.Errorf("Not running in acme")
+	}
+	if arg == "" {
+		arg = os.Getenv("winid")
+		if arg == "" {
+			return acme.WinInfo{}, fmt.Errorf("No window given and $winid is not set")
+		}
+	}
+
+	if id, err := strconv.Atoi(arg); err == nil {
+		for _, w := range wins {
+			if w.ID == id {
+				return w, nil
+			}
+		}
+	}
+
+	re, err := regexp.Compile(arg)
+	if err != nil {
+		re = regexp.MustCompile(regexp.QuoteMeta(arg))
+	}
+	var found []acme.WinInfo
+	for _, w := range wins {
+		if w.Name == arg {
+			return w, nil
+		}
+		if strings.HasSuffix(w.Name, "+Run") || w.Name == pickerName {
+			continue
+		}
+		if re.MatchString(w.Name) {
+			found = append(found, w)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return acme.WinInfo{}, fmt.Errorf("Window name does not match")
+	case 1:
+		return found[0], nil
+	}
+	return pick(found)
+}
+
+// pick lists wins in a window and returns the one whose line is clicked
+// with button 2 or 3.
+func pick(wins []acme.WinInfo) (acme.WinInfo, error) {
+	w, err := acme.New()
+	if err != nil {
+		return acme.WinInfo{}, err
+	}
+	defer w.CloseFiles()
+	w.Name(pickerName)
+	for _, wi := range wins {
+		w.Fprintf("body", "%d\t%s\n", wi.ID, wi.Name)
+	}
+	w.Ctl("clean")
+	w.Addr("0,0")
+	w.Ctl("dot=addr")
+
+	for e := range w.EventChan() {
+		if e.C2 != 'X' && e.C2 != 'L' {
+			w.WriteEvent(e)
+			continue
+		}
+		body, err := w.ReadAll("body")
+		if err != nil {
+			return acme.WinInfo{}, err
+		}
+		if n := lineAt(string(body), e.Q0); n < len(wins) {
+			w.Del(true)
+			return wins[n], nil
+		}
+	}
+	return acme.WinInfo{}, fmt.Errorf("No window picked")
+}
+
+// lineAt returns the line number, from 0, of the rune offset q in s.
+func lineAt(s string, q int) int {
+	n := 0
+	for i := 0; q > 0 && i < len(s); q-- {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == '\n' {
+			n++
+		}
+		i += size
+	}
+	return n
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"plramos.win/9fans/acme"
+)
+
+// placeholder matches {{name}} and $1 to $9 in scripts. The digit after
+// $N, if any, is matched too: $10 is no placeholder.
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}|\$([1-9])([0-9]?)`)
+
+// vars holds the values of the placeholders, from the command line and
+// the form asking for the missing ones.
+var vars = make(map[string]string)
+
+// parseArgs splits args into window names and name=value assignments,
+// which it adds to vars. A value @name is the selection, or else the
+// body, of the window name.
+func parseArgs(args []string) ([]string, error) {
+	var names []string
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			names = append(names, a)
+			continue
+		}
+		if strings.HasPrefix(v, "@") {
+			text, err := windowText(strings.TrimPrefix(v, "@"))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", k, err)
+			}
+			v = text
+		}
+		vars[k] = v
+	}
+	return names, nil
+}
+
+func windowText(name string) (string, error) {
+	info, err := findWindow(name)
+	if err != nil {
+		return "", err
+	}
+	w, err := acme.Open(info.ID, nil)
+	if err != nil {
+		return "", err
+	}
+	defer w.CloseFiles()
+	if sel := w.Selection(); sel != "" {
+		return sel, nil
+	}
+	b, err := w.ReadAll("body")
+	return strings.TrimSuffix(string(b), "\n"), err
+}
+
+// fillPlaceholders replaces the placeholders of src with their values in
+// vars. The missing ones are asked for in a form named after the window
+// name. $1 to $9 are common in shell scripts, so they are asked for only
+// when one of them is given; else they are left alone.
+func fillPlaceholders(src, name string) (string, error) {
+	positional := false
+	for k := range vars {
+		if len(k) == 1 && '1' <= k[0] && k[0] <= '9' {
+			positional = true
+		}
+	}
+	var missing []string
+	seen := make(map[string]bool)
+	for _, m := range placeholder.FindAllStringSubmatch(src, -1) {
+		k := m[1]
+		if m[2] != "" && m[3] == "" && positional {
+			k = m[2]
+		}
+		if k != "" && !seen[k] {
+			seen[k] = true
+			if _, ok := vars[k]; !ok {
+				missing = append(missing, k)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		if err := askVars(name, missing); err != nil {
+			return "", err
+		}
+	}
+	return placeholder.ReplaceAllStringFunc(src, func(s string) string {
+		m := placeholder.FindStringSubmatch(s)
+		if m[3] != "" {
+			return s
+		}
+		k := m[1] + m[2]
+		if v, ok := vars[k]; ok {
+			return v
+		}
+		return s
+	}), nil
+}
+
+// askVars opens a <name>+Vars window with a name= line for each of
+// missing and adds the values typed there to vars on Go.
+func askVars(name string, missing []string) error {
+	w, err := acme.New()
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+	winName := name + "+Vars"
+	w.Name(winName)
+	w.Fprintf("tag", "Go")
+	for _, k := range missing {
+		w.Fprintf("body", "%s=\n", k)
+	}
+	w.Ctl("clean")
+	w.Addr("0,0")
+	w.Ctl("dot=addr")
+
+	for e := range w.EventChan() {
+		if (e.C2 != 'x' && e.C2 != 'X') || string(e.Text) != "Go" {
+			w.WriteEvent(e)
+			continue
+		}
+		b, err := w.ReadAll("body")
+		if err != nil {
+			return err
+		}
+		given := make(map[string]string)
+		for _, line := range strings.Split(string(b), "\n") {
+			if k, v, ok := strings.Cut(line, "="); ok {
+				given[strings.TrimSpace(k)] = v
+			}
+		}
+		var still []string
+		for _, k := range missing {
+			if _, ok := given[k]; !ok {
+				still = append(still, k)
+			}
+		}
+		if len(still) > 0 {
+			acme.Errf(winName, "Missing %s", strings.Join(still, ", "))
+			continue
+		}
+		for k, v := range given {
+			vars[k] = v
+		}
+		w.Del(true)
+		return nil
+	}
+	return fmt.Errorf("No values given for %s", strings.Join(missing, ", "))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"plramos.win/9fans/acme"
+)
+
+// debounce is how long saves are left to settle before running again.
+const debounce = 500 * time.Millisecond
+
+// watch runs the window target every time it is put or its file changes,
+// in its +Run window cleared before each run. A run still going when the
+// next save comes is killed. It returns when either window is deleted or
+// a signal arrives on sigs.
+func watch(conf *config, target acme.WinInfo, sigs <-chan os.Signal) error {
+	o, err := openOutWin(target.Name)
+	if err != nil {
+		return err
+	}
+	if !o.owner {
+		// the window runs the jobs of another Run
+		o.conn.Close()
+		if o, err = newOutWin(target.Name + "+Run"); err != nil {
+			return err
+		}
+	}
+	jobs, unlisten, err := o.listen()
+	if err != nil {
+		return err
+	}
+	defer unlisten()
+	go refuse(jobs)
+	saves := make(chan bool, 1)
+	deleted := make(chan bool)
+	go watchLog(target.ID, saves, deleted)
+	go watchFile(target.Name, saves)
+
+	var (
+		cancel  context.CancelFunc = func() {}
+		running                    = make(chan bool)
+	)
+	close(running)
+	stop := func() {
+		cancel()
+		<-running
+	}
+	run := func() {
+		stop()
+		o.Clear()
+		j, err := windowJob(conf, target)
+		if err != nil {
+			o.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		running = make(chan bool)
+		go func(done chan bool) {
+			o.run(ctx, j)
+			close(done)
+		}(running)
+	}
+
+	events := o.EventChan()
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-saves:
+			timer.Reset(debounce)
+		case <-timer.C:
+			run()
+		case <-deleted:
+			stop()
+			return nil
+		case <-sigs:
+			stop()
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				stop()
+				return nil
+			}
+			if e.C2 != 'x' && e.C2 != 'X' {
+				o.WriteEvent(e)
+				continue
+			}
+			switch string(e.Text) {
+			case "Rerun":
+				run()
+			case "Kill":
+				cancel()
+			case "Del":
+				stop()
+				o.WriteEvent(e)
+			default:
+				o.WriteEvent(e)
+			}
+		}
+	}
+}
+
+// watchLog sends on saves when the window id is put and closes deleted
+// when it is deleted.
+func watchLog(id int, saves chan<- bool, deleted chan<- bool) {
+	lr, err := acme.Log()
+	if err != nil {
+		return
+	}
+	defer lr.Close()
+	for {
+		ev, err := lr.Read()
+		if err != nil {
+			return
+		}
+		if ev.ID != id {
+			continue
+		}
+		switch ev.Op {
+		case "put":
+			select {
+			case saves <- true:
+			default:
+			}
+		case "del":
+			close(deleted)
+			return
+		}
+	}
+}
+
+// watchFile sends on saves when the file name changes on disk.
+func watchFile(name string, saves chan<- bool) {
+	var last time.Time
+	if info, err := os.Stat(name); err == nil {
+		last = info.ModTime()
+	}
+	for range time.Tick(debounce / 2) {
+		info, err := os.Stat(name)
+		if err != nil || info.ModTime().Equal(last) {
+			continue
+		}
+		last = info.ModTime()
+		select {
+		case saves <- true:
+		default:
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// probe is a word no dictionary knows, spelled at the start of a line to
+// learn how aspell counts offsets of lines starting with ^.
+const probe = "xqzwvkj"
+
+// A speller is an aspell -a process in terse mode, kept running to spell
+// one line after another.
+type speller struct {
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	out  *bufio.Scanner
+	bias int // added by aspell to the offsets
+}
+
+// A miss is a word aspell does not know, its offset in the line and its
+// suggestions, if any.
+type miss struct {
+	word        string
+	off         int
+	corrections string
+}
+
+func newSpeller(args ...string) (*speller, error) {
+	cmd := exec.Command("aspell", append([]string{"-a"}, args...)...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	s := &speller{cmd: cmd, in: in, out: bufio.NewScanner(out)}
+	s.out.Buffer(nil, 1024*1024)
+	if !s.out.Scan() { // banner
+		s.Close()
+		return nil, fmt.Errorf("aspell did not start: %v", s.out.Err())
+	}
+	if _, err := io.WriteString(in, "!\n"); err != nil {
+		s.Close()
+		return nil, err
+	}
+	misses, err := s.check(probe)
+	if err != nil || len(misses) != 1 {
+		s.Close()
+		return nil, fmt.Errorf("aspell does not answer as expected")
+	}
+	s.bias = misses[0].off
+	return s, nil
+}
+
+// check spells line. Aspell answers a line with a line per miss and an
+// empty one; the ^ keeps the line from being read as a command.
+func (s *speller) check(line string) ([]miss, error) {
+	if _, err := io.WriteString(s.in, "^"+line+"\n"); err != nil {
+		return nil, err
+	}
+	var misses []miss
+	for s.out.Scan() {
+		text := s.out.Text()
+		if text == "" {
+			return misses, nil
+		}
+		switch text[0] {
+		case '&': // & word count offset: suggestions
+			info, corrections, _ := strings.Cut(text, ":")
+			f := strings.Split(info, " ")
+			if len(f) < 4 {
+				return nil, fmt.Errorf("invalid answer from aspell: %s", text)
+			}
+			off, err := strconv.Atoi(f[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid address from aspell")
+			}
+			misses = append(misses, miss{word: f[1], off: off - s.bias, corrections: corrections})
+		case '#': // # word offset
+			f := strings.Split(text, " ")
+			if len(f) < 3 {
+				return nil, fmt.Errorf("invalid answer from aspell: %s", text)
+			}
+			off, err := strconv.Atoi(f[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid address from aspell")
+			}
+			misses = append(misses, miss{word: f[1], off: off - s.bias})
+		}
+	}
+	if err := s.out.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (s *speller) Close() error {
+	s.in.Close()
+	return s.cmd.Wait()
+}
+
+// spell returns the misspells of text, which starts at the rune offset
+// offset of its window.
+func spell(s *speller, text string, offset int) ([]misspell, error) {
+	var misspells []misspell
+	courpus := bufio.NewScanner(strings.NewReader(text))
+	qconsumed := 0
+	for courpus.Scan() {
+		misses, err := s.check(courpus.Text())
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range misses {
+			content := "> " + m.word + "\n~no corrections~"
+			if m.corrections != "" {
+				content = "> " + m.word + "\n" + strings.ReplaceAll(m.corrections, ", ", "\n")
+			}
+			misspells = append(misspells, misspell{
+				word:       m.word,
+				wincontent: content,
+				q0:         m.off + offset + qconsumed,
+				q1:         m.off + offset + qconsumed + len(m.word),
+			})
+		}
+		qconsumed += len(courpus.Text()) + 1
+	}
+	return misspells, courpus.Err()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// % !TeX spellcheck = pt_PT
+	texHint = regexp.MustCompile(`(?im)^%+\s*!TeX\s+spellcheck\s*=\s*(\S+)`)
+	// \usepackage[english,portuguese]{babel}, the last language, or the
+	// main= one, is the main language; commented out lines do not count
+	babelHint = regexp.MustCompile(`(?m)^[^%\n]*\\usepackage\s*\[([^\]]*)\]\s*\{babel\}`)
+	// lang: pt-PT in the front matter of a Markdown document
+	frontLang = regexp.MustCompile(`^lang(?:uage)?:\s*["']?([^"'\s]+)`)
+)
+
+// babelLangs maps babel language names to aspell dictionaries.
+var babelLangs = map[string]string{
+	"english":    "en",
+	"american":   "en_US",
+	"USenglish":  "en_US",
+	"british":    "en_GB",
+	"UKenglish":  "en_GB",
+	"portuguese": "pt_PT",
+	"portuges":   "pt_PT",
+	"brazilian":  "pt_BR",
+	"brazil":     "pt_BR",
+	"spanish":    "es",
+	"french":     "fr",
+	"german":     "de",
+	"ngerman":    "de",
+	"italian":    "it",
+	"dutch":      "nl",
+}
+
+// docLang returns the language a document asks to be spelled in, from a
+// TeX spellcheck comment, its babel languages or its front matter. It is
+// "" when there is no hint.
+func docLang(doc string) string {
+	if m := texHint.FindStringSubmatch(doc); m != nil {
+		return normLang(m[1])
+	}
+	if m := babelHint.FindStringSubmatch(doc); m != nil {
+		opts := strings.Split(m[1], ",")
+		for _, opt := range opts {
+			opt = strings.TrimSpace(opt)
+			if strings.HasPrefix(opt, "main=") {
+				if lang, ok := babelLangs[strings.TrimPrefix(opt, "main=")]; ok {
+					return lang
+				}
+			}
+		}
+		for i := len(opts) - 1; i >= 0; i-- {
+			if lang, ok := babelLangs[strings.TrimSpace(opts[i])]; ok {
+				return lang
+			}
+		}
+	}
+	if strings.HasPrefix(doc, "---\n") {
+		for _, line := range strings.Split(strings.TrimPrefix(doc, "---\n"), "\n") {
+			if line == "---" || line == "..." {
+				break
+			}
+			if m := frontLang.FindStringSubmatch(line); m != nil {
+				return normLang(m[1])
+			}
+		}
+	}
+	return ""
+}
+
+// normLang turns pt-PT and the like into the pt_PT aspell expects.
+func normLang(lang string) string {
+	return strings.ReplaceAll(lang, "-", "_")
+}
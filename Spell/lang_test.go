@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestDocLang(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		lang string
+	}{
+		{"tex comment", "% !TeX spellcheck = pt-PT\n\\documentclass{article}\n", "pt_PT"},
+		{"tex comment over babel", "% !TEX spellcheck = de\n\\usepackage[english]{babel}\n", "de"},
+		{"babel main", "\\usepackage[main=portuguese,english]{babel}\n", "pt_PT"},
+		{"babel last", "\\usepackage[english, brazilian]{babel}\n", "pt_BR"},
+		{"babel unknown last", "\\usepackage[french,klingon]{babel}\n", "fr"},
+		{"babel indented", "  \\usepackage [british] {babel}\n", "en_GB"},
+		{"babel commented", "% \\usepackage[portuguese]{babel}\n\\usepackage[british]{babel}\n", "en_GB"},
+		{"babel commented only", "%\\usepackage[portuguese]{babel}\n", ""},
+		{"babel commented after", "\\documentclass{article} % \\usepackage[german]{babel}\n", ""},
+		{"front matter", "---\ntitle: x\nlang: pt-PT\n---\ntext\n", "pt_PT"},
+		{"front matter language", "---\nlanguage: 'en-GB'\n---\n", "en_GB"},
+		{"after front matter", "---\ntitle: x\n---\nlang: fr\n", ""},
+		{"no front matter", "lang: fr\n", ""},
+		{"no hint", "\\documentclass{article}\nHello.\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := docLang(tt.doc); got != tt.lang {
+				t.Errorf("docLang(%q) = %q, want %q", tt.doc, got, tt.lang)
+			}
+		})
+	}
+}
@@ -1,27 +1,28 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"io"
+	"flag"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"unicode/utf8"
 
 	"plramos.win/9fans/acme"
 )
 
+// The language is the one given with -l, the one the document asks for
+// in a "% !TeX spellcheck = pt_PT" comment, its babel options or the lang:
+// key of its front matter, or else $slang. Without any, aspell picks.
+//
 // TODO:
-// 	+ add slang env variable to select language to spell
 // 	+ add filters like latex and markdown
 // 	+ Show commnand?
 
 var DocID = os.Getenv("winid")
 
+var langFlag = flag.String("l", "", "Spell in this language, such as en or pt_PT")
+
 type misspell struct {
 	word       string
 	wincontent string
@@ -35,6 +36,8 @@ func main() {
 		docname   string     // docpath
 	)
 
+	flag.Parse()
+
 	id, _ := strconv.Atoi(DocID)
 	windoc, _ := acme.Open(id, nil)
 	if windoc == nil {
@@ -61,6 +64,22 @@ func main() {
 	default:
 		aspellMode = ""
 	}
+	lang := *langFlag
+	if lang == "" {
+		b, _ := windoc.ReadAll("body")
+		lang = docLang(string(b))
+	}
+	if lang == "" {
+		lang = os.Getenv("slang")
+	}
+	var aspellArgs []string
+	if aspellMode != "" {
+		aspellArgs = append(aspellArgs, aspellMode)
+	}
+	if lang != "" {
+		aspellArgs = append(aspellArgs, "--lang="+normLang(lang))
+	}
+
 	offset, _, err := windoc.SelectionAddr()
 	if err != nil {
 		log.Fatalf("Could not read content: %v", err)
@@ -74,74 +93,14 @@ func main() {
 		courpusraw = string(b)
 	}
 
-	sr := strings.NewReader(courpusraw)
-	courpus := bufio.NewScanner(sr)
-
-	qconsumed := 0
-	for courpus.Scan() {
-		cmd := exec.Command("aspell", "-a", aspellMode)
-		stdin, err := cmd.StdinPipe()
-		if err != nil {
-			log.Fatal(err)
-		}
-		go func() {
-			defer stdin.Close()
-			io.WriteString(stdin, courpus.Text())
-		}()
-
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Fatal(err)
-		}
-		br := bytes.NewReader(out)
-		aspellout := bufio.NewScanner(br)
-		aspellout.Scan() // discard first output line
-		for aspellout.Scan() {
-			// empty line
-			if c, _ := utf8.DecodeRune(aspellout.Bytes()); c == '\n' {
-				continue
-			}
-			// new line
-			if c, _ := utf8.DecodeRune(aspellout.Bytes()); c == '*' {
-				continue
-			}
-
-			// wrong word with suggestions
-			if c, _ := utf8.DecodeRune(aspellout.Bytes()); c == '&' {
-				info, corrections, _ := strings.Cut(aspellout.Text(), ":")
-
-				word := strings.Split(info, " ")[1]
-				qstr := strings.Split(info, " ")[3]
-				q0, err := strconv.Atoi(qstr)
-				if err != nil {
-					log.Fatalf("invalid address from aspell")
-				}
-
-				misspells = append(misspells, misspell{
-					word:       word,
-					wincontent: "> " + word + "\n" + strings.ReplaceAll(corrections, ", ", "\n"),
-					q0:         q0 + offset + qconsumed,
-					q1:         q0 + offset + qconsumed + len(word),
-				})
-			}
-			// error but no suggestion
-			if c, _ := utf8.DecodeRune(aspellout.Bytes()); c == '#' {
-				word := strings.Split(aspellout.Text(), " ")[1]
-				qstr := strings.Split(aspellout.Text(), " ")[2]
-				q0, err := strconv.Atoi(qstr)
-				if err != nil {
-					log.Fatalf("invalid address from aspell")
-				}
-				misspells = append(misspells, misspell{
-					word:       word,
-					wincontent: "> " + word + "\n~no corrections~",
-					q0:         q0 + offset + qconsumed,
-					q1:         q0 + offset + qconsumed + len(word),
-				})
-				continue
-			}
-		}
-		qconsumed += len(courpus.Text()) + 1
+	sp, err := newSpeller(aspellArgs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	misspells, err = spell(sp, courpusraw, offset)
+	sp.Close()
+	if err != nil {
+		log.Fatal(err)
 	}
 	// dummy correction to prevent program exit
 	misspells = append(misspells, misspell{
@@ -153,7 +112,11 @@ func main() {
 	wspell, _ := acme.New()
 	wspell.Name(docname + "+corrections")
 	wspell.Ctl("cleartag")
-	wspell.Fprintf("tag", " Next Previous Fix")
+	dict := "default"
+	if lang != "" {
+		dict = normLang(lang)
+	}
+	wspell.Fprintf("tag", " Next Previous Fix dict:%s", dict)
 
 NextWord:
 	for i := 0; i < len(misspells); i++ {
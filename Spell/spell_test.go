@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// aspellArgs returns the aspell arguments for the test corpus, skipping
+// the test when aspell or the dictionary is missing.
+func aspellArgs(tb testing.TB, doc string) []string {
+	if _, err := exec.LookPath("aspell"); err != nil {
+		tb.Skip("no aspell")
+	}
+	args := []string{"--mode=tex", "--lang=" + docLang(doc)}
+	sp, err := newSpeller(args...)
+	if err != nil {
+		tb.Skipf("aspell %s: %v", strings.Join(args, " "), err)
+	}
+	sp.Close()
+	return args
+}
+
+func corpus(tb testing.TB) string {
+	doc, err := os.ReadFile("test/cv.tex")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return string(doc)
+}
+
+// perLine spells text as Spell used to: an aspell process for every line.
+func perLine(tb testing.TB, args []string, text string) []misspell {
+	var misspells []misspell
+	lines := bufio.NewScanner(strings.NewReader(text))
+	qconsumed := 0
+	for lines.Scan() {
+		cmd := exec.Command("aspell", append([]string{"-a"}, args...)...)
+		cmd.Stdin = strings.NewReader(lines.Text())
+		out, err := cmd.Output()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		answer := bufio.NewScanner(bytes.NewReader(out))
+		answer.Scan() // banner
+		for answer.Scan() {
+			text := answer.Text()
+			switch {
+			case strings.HasPrefix(text, "&"):
+				info, corrections, _ := strings.Cut(text, ":")
+				f := strings.Split(info, " ")
+				q0, _ := strconv.Atoi(f[3])
+				misspells = append(misspells, misspell{
+					word:       f[1],
+					wincontent: "> " + f[1] + "\n" + strings.ReplaceAll(corrections, ", ", "\n"),
+					q0:         q0 + qconsumed,
+					q1:         q0 + qconsumed + len(f[1]),
+				})
+			case strings.HasPrefix(text, "#"):
+				f := strings.Split(text, " ")
+				q0, _ := strconv.Atoi(f[2])
+				misspells = append(misspells, misspell{
+					word:       f[1],
+					wincontent: "> " + f[1] + "\n~no corrections~",
+					q0:         q0 + qconsumed,
+					q1:         q0 + qconsumed + len(f[1]),
+				})
+			}
+		}
+		qconsumed += len(lines.Text()) + 1
+	}
+	return misspells
+}
+
+// TestSpellPerLine checks the pipe finds what one process per line did.
+// Lines starting with an aspell command were read as commands then, so
+// they are blanked out here.
+func TestSpellPerLine(t *testing.T) {
+	doc := corpus(t)
+	args := aspellArgs(t, doc)
+	lines := strings.SplitAfter(doc, "\n")
+	for i, l := range lines {
+		if l != "" && strings.ContainsRune("*&@+-~#!%^$", rune(l[0])) {
+			body := strings.TrimSuffix(l, "\n")
+			lines[i] = strings.Repeat(" ", len(body)) + l[len(body):]
+		}
+	}
+	text := strings.Join(lines, "")
+
+	sp, err := newSpeller(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	got, err := spell(sp, text, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := perLine(t, args, text)
+	if len(want) == 0 {
+		t.Fatal("no misspells in the corpus")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spell found %d misspells, per line %d", len(got), len(want))
+		for i := 0; i < len(got) && i < len(want); i++ {
+			if got[i] != want[i] {
+				t.Errorf("first difference: %+v, per line %+v", got[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+// TestSpellCommands checks lines starting with aspell commands are spelled
+// as text and their offsets are right, the ^ bias taken away.
+func TestSpellCommands(t *testing.T) {
+	if _, err := exec.LookPath("aspell"); err != nil {
+		t.Skip("no aspell")
+	}
+	sp, err := newSpeller()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer sp.Close()
+	var text strings.Builder
+	for _, c := range []string{"", "%", "*", "&", "#", "!", "^", "@", "+", "-", "~"} {
+		text.WriteString(c + probe + " " + probe + "\n")
+	}
+	got, err := spell(sp, text.String(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []int
+	for i := 0; i < len(text.String()); {
+		j := strings.Index(text.String()[i:], probe)
+		if j < 0 {
+			break
+		}
+		want = append(want, 10+i+j)
+		i += j + len(probe)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("spell found %d misspells, want %d: %+v", len(got), len(want), got)
+	}
+	for i, m := range got {
+		if m.word != probe || m.q0 != want[i] || m.q1 != want[i]+len(probe) {
+			t.Errorf("misspell %d = %q #%d,#%d, want %q #%d,#%d", i, m.word, m.q0, m.q1, probe, want[i], want[i]+len(probe))
+		}
+	}
+}
+
+// BenchmarkSpell spells the test corpus through one aspell pipe.
+func BenchmarkSpell(b *testing.B) {
+	doc := corpus(b)
+	args := aspellArgs(b, doc)
+	for i := 0; i < b.N; i++ {
+		sp, err := newSpeller(args...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := spell(sp, doc, 0); err != nil {
+			b.Fatal(err)
+		}
+		sp.Close()
+	}
+}
+
+// BenchmarkSpellPerLine spells the test corpus running aspell once per
+// line, as Spell used to.
+func BenchmarkSpellPerLine(b *testing.B) {
+	doc := corpus(b)
+	args := aspellArgs(b, doc)
+	for i := 0; i < b.N; i++ {
+		perLine(b, args, doc)
+	}
+}
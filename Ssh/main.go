@@ -9,14 +9,38 @@
 //	user <user>
 //	password
 //	key <path to keyfile>
+//	log
+//	root <remote path>
+//	readonly
+//	reconnect [seconds]
+//	uid <id>
+//	gid <id>
+//	sshfs <option>
 //
 // Only one of key or password is needed. Password is simply a flag to indicate that the password should be asked.
+// Log records a timestamped transcript of every session under .logs in the directory; -l does it for every entry.
+//
+// The remaining keys tune Mnt. Root is the remote directory to mount instead of the home directory, readonly
+// mounts it read only and reconnect keeps the mount alive, pinging the server every few seconds (15 by default).
+// Uid and gid set the owner of the mounted files and sshfs passes any other option to sshfs -o; it may be repeated.
+// Password entries are mounted asking the password through $SSH_ASKPASS, or ssh-askpass when it is not set.
 //
 // By default the files will be located in $home/lib/coms/ssh
+//
+// Run <cmd> runs cmd on the selected entry without a terminal and shows its output in a +out window.
+//
+// Rm moves the selected entry, its transcripts and the keys only it uses under -d to .trash in the directory,
+// after unmounting it and stopping its commands. Undelete lists the trash and restores what is clicked there.
+//
+// Info opens the selected descriptor for editing. Put in that window checks the settings before writing it back.
+//
+// The directory is watched, so the +list window follows descriptors being
+// added, removed or renamed. Get rescans it by hand.
 package main // plramos.win/acme-cmd/Ssh
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
@@ -24,6 +48,9 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,7 +58,7 @@ import (
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: Ssh -d directory \n")
+	fmt.Fprintf(os.Stderr, "usage: Ssh [-l] -d directory \n")
 	os.Exit(2)
 }
 
@@ -39,27 +66,54 @@ var HomeEnv = os.Getenv("HOME")
 var MntEnv = os.Getenv("9MNT")
 var defaultDir = fmt.Sprintf("%s/lib/coms/ssh", HomeEnv)
 var sshDir = flag.String("d", defaultDir, "Directory contianing all the ssh connection description")
+var logAll = flag.Bool("l", false, "Keep a transcript of every session")
 
 type Server struct {
 	host, user, key string
-	password        bool
+	password, log   bool
+
+	// sshfs mount options
+	root, uid, gid      string
+	readonly, reconnect bool
+	alive               int
+	sshfsOpts           []string
 }
 
 func main() {
+	flag.Usage = usage
+	flag.Parse()
 	if MntEnv == "" {
 		MntEnv = HomeEnv + "/n"
 	}
 	w, _ := acme.New()
 	w.Name("%s/ssh/+list", MntEnv)
-	w.Fprintf("tag", "Get Dial Info Add Mnt")
+	w.Fprintf("tag", "Get Dial Info Add Mnt Logs Run Rm Undelete")
 	fileSystem := os.DirFS(*sshDir)
 	writeSshEntries(w, fileSystem)
 
-	for e := range w.EventChan() {
+	refresh := make(chan bool, 1)
+	if err := watchDir(*sshDir, refresh); err != nil {
+		log.Printf("Not watching %s: %v", *sshDir, err)
+	}
+
+	events := w.EventChan()
+	for {
+		var e *acme.Event
+		select {
+		case <-refresh:
+			refreshList(w, fileSystem)
+			continue
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			e = ev
+		}
 		switch e.C2 {
 		case 'x': // execute in tag
 			// Get Dial Info Add Rm
 			if string(e.Text) == "Get" {
+				refreshList(w, fileSystem)
 				continue
 			}
 			if string(e.Text) == "Dial" {
@@ -74,16 +128,37 @@ func main() {
 			}
 			if string(e.Text) == "Info" {
 				sshConfig := strings.TrimSpace(w.Selection())
-				f, err := fileSystem.Open(sshConfig)
+				b, err := fs.ReadFile(fileSystem, sshConfig)
 				if err != nil {
 					w.Fprintf("body", "Cannot open %s: %v\n", sshConfig, err)
 					w.Ctl("clean")
+					continue
 				}
-				displayInfo(f, sshConfig)
+				go displayInfo(b, sshConfig, refresh)
 			}
 			if string(e.Text) == "Add" {
 				createEntry()
 			}
+			if string(e.Text) == "Logs" {
+				displayLogs(strings.TrimSpace(w.Selection()))
+			}
+			if string(e.Text) == "Rm" {
+				name := strings.TrimSpace(w.Selection())
+				if err := removeEntry(name, fileSystem); err != nil {
+					log.Printf("Cannot remove %s: %v", name, err)
+					continue
+				}
+				refreshList(w, fileSystem)
+			}
+			if string(e.Text) == "Undelete" {
+				go displayTrash(refresh)
+			}
+			if verb, arg, _ := strings.Cut(string(e.Text), " "); verb == "Run" {
+				if len(e.Arg) > 0 {
+					arg = string(e.Arg)
+				}
+				runEntry(strings.TrimSpace(w.Selection()), strings.TrimSpace(arg), fileSystem)
+			}
 		case 'X': // executes in body
 			dial(w, e, fileSystem)
 		
@@ -113,8 +188,14 @@ func writeSshEntries(w *acme.Win, fileSystem fs.FS) {
 		if err != nil {
 			w.Fprintf("body", "Error on %s: %v\n", path, err)
 			w.Ctl("clean")
+			if d == nil {
+				return nil
+			}
 		}
 		if d.IsDir() {
+			if path != "." && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
 			return nil
 		}
 		if path != "." {
@@ -127,6 +208,21 @@ func writeSshEntries(w *acme.Win, fileSystem fs.FS) {
 	w.Ctl("clean")
 }
 
+// refreshList rescans the descriptors into w. The entry selected before the
+// rescan stays selected if it still exists.
+func refreshList(w *acme.Win, fileSystem fs.FS) {
+	sel := strings.TrimSpace(w.Selection())
+	w.Clear()
+	writeSshEntries(w, fileSystem)
+	if sel != "" && w.Addr("/^%s$/", regexp.QuoteMeta(sel)) == nil {
+		w.Ctl("dot=addr")
+		w.Ctl("show")
+		return
+	}
+	w.Addr("0,0")
+	w.Ctl("dot=addr")
+}
+
 func dial(w *acme.Win, e *acme.Event, fileSystem fs.FS) {
 	sshConfig := strings.TrimSpace(string(e.Text))
 	w.Del(true)
@@ -163,24 +259,66 @@ func dial(w *acme.Win, e *acme.Event, fileSystem fs.FS) {
 		defer w.Ctl("clean")
 		defer w.Fprintf("body", "--SSH TERMINATED--\n")
 
+		if server.log || *logAll {
+			stop, err := startTranscript(w, sshConfig, strings.Join(sshCmd, " "))
+			if err != nil {
+				log.Printf("Could not record session: %v", err)
+			} else {
+				defer stop()
+			}
+		}
 	}
 	cmd.Wait()
 }
 
-func displayInfo(f fs.File, path string) error {
-	w, _ := acme.New()
-	w.Name(fmt.Sprintf("%s/ssh/%s/+info", MntEnv, path))
+// displayInfo opens the descriptor path, whose content is b, for editing.
+// Put checks the settings and writes it back, then asks for the list to
+// be refreshed on refresh.
+func displayInfo(b []byte, path string, refresh chan<- bool) {
+	w, err := acme.New()
+	if err != nil {
+		log.Printf("Cannot open info window: %v", err)
+		return
+	}
+	defer w.CloseFiles()
+	winName := fmt.Sprintf("%s/ssh/%s/+info", MntEnv, path)
+	w.Name(winName)
+	w.Fprintf("tag", "Put")
+	w.Write("body", b)
+	w.Ctl("clean")
+	w.Addr("0,0")
+	w.Ctl("dot=addr")
+	w.Ctl("show")
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		if strings.TrimSpace(scanner.Text()) == "--end--" {
-			break
+	for e := range w.EventChan() {
+		if (e.C2 != 'x' && e.C2 != 'X') || string(e.Text) != "Put" {
+			w.WriteEvent(e)
+			continue
+		}
+		body, err := w.ReadAll("body")
+		if err != nil {
+			acme.Errf(winName, "Cannot read %s: %v", path, err)
+			continue
+		}
+		if _, err := parseConfig(bytes.NewReader(body)); err != nil {
+			acme.Errf(winName, "Not saved: %v", err)
+			continue
+		}
+		file := filepath.Join(*sshDir, path)
+		perm := fs.FileMode(0600)
+		if info, err := os.Stat(file); err == nil {
+			perm = info.Mode().Perm()
+		}
+		if err := os.WriteFile(file, body, perm); err != nil {
+			acme.Errf(winName, "Cannot save %s: %v", path, err)
+			continue
+		}
+		w.Ctl("clean")
+		select {
+		case refresh <- true:
+		default:
 		}
-		w.Write("body", scanner.Bytes())
-		w.Write("body", []byte("\n"))
 	}
-	w.Ctl("clean")
-	return nil
 }
 
 func sshFS(w *acme.Win, e *acme.Event, fileSystem fs.FS) {
@@ -203,14 +341,13 @@ func sshFS(w *acme.Win, e *acme.Event, fileSystem fs.FS) {
 
 	mntPoint := fmt.Sprintf("%s/ssh/fs/%s", MntEnv, sshConfig)
 	os.MkdirAll(mntPoint, 0770)
-	fsCmdArgs := []string{
-		"-C",
-		"-o",
-		fmt.Sprintf("IdentityFile=%s", s.key),
-		fmt.Sprintf("%s@%s:", s.user, s.host),
-		mntPoint,
+	sshFS := exec.Command("sshfs", sshfsArgs(s, mntPoint)...)
+	if s.password {
+		if sshFS.Env, err = askpassEnv(); err != nil {
+			log.Printf("Could not mount sshfs: %v", err)
+			return
+		}
 	}
-	sshFS := exec.Command("sshfs", fsCmdArgs...)
 	stderr, _ := sshFS.StderrPipe()
 	go io.Copy(os.Stderr, stderr)
 	if err := sshFS.Start(); err != nil {
@@ -221,6 +358,53 @@ func sshFS(w *acme.Win, e *acme.Event, fileSystem fs.FS) {
 	sshDirWin.Ctl("get")
 }
 
+// sshfsArgs builds the sshfs arguments mounting the server s on mntPoint.
+func sshfsArgs(s Server, mntPoint string) []string {
+	var opts []string
+	if !s.password {
+		opts = append(opts, fmt.Sprintf("IdentityFile=%s", s.key))
+	}
+	if s.readonly {
+		opts = append(opts, "ro")
+	}
+	if s.reconnect {
+		opts = append(opts, "reconnect", fmt.Sprintf("ServerAliveInterval=%d", s.alive), "ServerAliveCountMax=3")
+	}
+	if s.uid != "" {
+		opts = append(opts, "uid="+s.uid)
+	}
+	if s.gid != "" {
+		opts = append(opts, "gid="+s.gid)
+	}
+	opts = append(opts, s.sshfsOpts...)
+
+	args := []string{"-C"}
+	for _, o := range opts {
+		args = append(args, "-o", o)
+	}
+	return append(args, fmt.Sprintf("%s@%s:%s", s.user, s.host, s.root), mntPoint)
+}
+
+// askpassEnv is the environment for ssh to ask a password without a
+// terminal: through $SSH_ASKPASS, or ssh-askpass when it is not set. It
+// fails when there is no such program or no display to show it on.
+func askpassEnv() ([]string, error) {
+	askpass := os.Getenv("SSH_ASKPASS")
+	if askpass == "" {
+		askpass = "ssh-askpass"
+	}
+	if _, err := exec.LookPath(askpass); err != nil {
+		return nil, fmt.Errorf("cannot ask for the password: %v", err)
+	}
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil, fmt.Errorf("cannot ask for the password: no display for %s", askpass)
+	}
+	return append(os.Environ(), "SSH_ASKPASS="+askpass, "SSH_ASKPASS_REQUIRE=force"), nil
+}
+
+// flagKeys are the descriptor keys that may go without a value.
+var flagKeys = map[string]bool{"password": true, "log": true, "readonly": true, "reconnect": true}
+
 func parseConfig(f io.Reader) (Server, error) {
 	scanner := bufio.NewScanner(f)
 	var b strings.Builder
@@ -240,6 +424,9 @@ func parseConfig(f io.Reader) (Server, error) {
 			continue
 		}
 		f := strings.Fields(line)
+		if len(f) < 2 && !flagKeys[f[0]] {
+			return s, fmt.Errorf("Bad ssh descriptor: %s needs a value", f[0])
+		}
 		switch f[0] {
 		case "host":
 			s.host = f[1]
@@ -249,6 +436,28 @@ func parseConfig(f io.Reader) (Server, error) {
 			s.password = true
 		case "key":
 			s.key = f[1]
+		case "log":
+			s.log = true
+		case "root":
+			s.root = strings.TrimSpace(strings.TrimPrefix(line, f[0]))
+		case "readonly":
+			s.readonly = true
+		case "reconnect":
+			s.reconnect = true
+			s.alive = 15
+			if len(f) > 1 {
+				n, err := strconv.Atoi(f[1])
+				if err != nil || n <= 0 {
+					return s, fmt.Errorf("Bad ssh descriptor: reconnect %s is not a number of seconds", f[1])
+				}
+				s.alive = n
+			}
+		case "uid":
+			s.uid = f[1]
+		case "gid":
+			s.gid = f[1]
+		case "sshfs":
+			s.sshfsOpts = append(s.sshfsOpts, f[1])
 		}
 	}
 	if s.host == "" || (!s.password && s.key == "") || s.user == "" {
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// isMounted reports whether dir is a mount point. sshfs only runs on unix.
+func isMounted(dir string) bool {
+	return false
+}
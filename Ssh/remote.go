@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os/exec"
+	"sync"
+
+	"plramos.win/9fans/acme"
+)
+
+// runEntry runs command on the server described by the entry name.
+func runEntry(name, command string, fileSystem fs.FS) {
+	if name == "" || command == "" {
+		log.Printf("Run needs a selected entry and a command")
+		return
+	}
+	f, err := fileSystem.Open(name)
+	if err != nil {
+		log.Printf("Cannot open %s: %v", name, err)
+		return
+	}
+	s, err := parseConfig(f)
+	f.Close()
+	if err != nil {
+		log.Printf("%s: %v", name, err)
+		return
+	}
+	go remoteRun(name, s, command)
+}
+
+// remoteRun shows the output of command, run on s, in the +out window of
+// the entry name. Rerun runs it again and Kill stops it.
+func remoteRun(name string, s Server, command string) {
+	w, err := acme.New()
+	if err != nil {
+		log.Printf("Cannot open output window: %v", err)
+		return
+	}
+	defer w.CloseFiles()
+	w.Name("%s/ssh/%s/+out", MntEnv, name)
+	w.Fprintf("tag", "Rerun Kill")
+
+	var (
+		cancel  context.CancelFunc
+		running chan bool
+	)
+	start := func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		running = make(chan bool)
+		w.Clear()
+		w.Fprintf("body", "%% ssh %s@%s %s\n", s.user, s.host, command)
+		go func(cancel context.CancelFunc, done chan bool) {
+			remotes.add(name, &cancel)
+			execRemote(ctx, w, s, command)
+			remotes.remove(name, &cancel)
+			close(done)
+		}(cancel, running)
+	}
+	stop := func() {
+		cancel()
+		<-running
+	}
+
+	start()
+	for e := range w.EventChan() {
+		if e.C2 != 'x' && e.C2 != 'X' {
+			w.WriteEvent(e)
+			continue
+		}
+		switch string(e.Text) {
+		case "Rerun":
+			stop()
+			start()
+		case "Kill":
+			cancel()
+		case "Del":
+			stop()
+			w.WriteEvent(e)
+		default:
+			w.WriteEvent(e)
+		}
+	}
+	stop()
+}
+
+// execRemote runs command on s writing its output and exit status to w.
+func execRemote(ctx context.Context, w *acme.Win, s Server, command string) {
+	args := []string{"-T", s.host, "-l", s.user}
+	if !s.password {
+		args = append(args, "-i", s.key)
+	}
+	cmd := exec.CommandContext(ctx, "ssh", append(args, "--", command)...)
+	if s.password {
+		var err error
+		if cmd.Env, err = askpassEnv(); err != nil {
+			w.Fprintf("body", "--FAILED: %v--\n", err)
+			w.Ctl("clean")
+			return
+		}
+	}
+	out := &bodyWriter{w: w}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		w.Fprintf("body", "--KILLED--\n")
+	case err == nil:
+		w.Fprintf("body", "--EXIT STATUS 0--\n")
+	case errors.As(err, &exitErr):
+		w.Fprintf("body", "--EXIT STATUS %d--\n", exitErr.ExitCode())
+	default:
+		w.Fprintf("body", "--FAILED: %v--\n", err)
+	}
+	w.Ctl("clean")
+}
+
+// remotes keeps the cancel functions of the commands running for each entry.
+var remotes = remoteSet{m: make(map[string]map[*context.CancelFunc]bool)}
+
+type remoteSet struct {
+	sync.Mutex
+	m map[string]map[*context.CancelFunc]bool
+}
+
+func (r *remoteSet) add(name string, cancel *context.CancelFunc) {
+	r.Lock()
+	defer r.Unlock()
+	if r.m[name] == nil {
+		r.m[name] = make(map[*context.CancelFunc]bool)
+	}
+	r.m[name][cancel] = true
+}
+
+func (r *remoteSet) remove(name string, cancel *context.CancelFunc) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.m[name], cancel)
+	if len(r.m[name]) == 0 {
+		delete(r.m, name)
+	}
+}
+
+// stop cancels every command running for the entry name.
+func (r *remoteSet) stop(name string) {
+	r.Lock()
+	defer r.Unlock()
+	for cancel := range r.m[name] {
+		(*cancel)()
+	}
+}
+
+// bodyWriter appends everything written to it to the body of w.
+type bodyWriter struct {
+	mu sync.Mutex
+	w  *acme.Win
+}
+
+func (b *bodyWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.w.Write("body", p); err != nil {
+		return 0, fmt.Errorf("writing output: %w", err)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"plramos.win/9fans/acme"
+)
+
+// logsDir keeps the session transcripts, one directory per entry.
+const logsDir = ".logs"
+
+// logName is the layout of transcript file names. It avoids ':' so the
+// names can be opened with button 3.
+const logName = "20060102-150405.log"
+
+const stampFmt = "2006-01-02 15:04:05"
+
+// startTranscript records what the session window w shows into a new
+// transcript of the entry name. The returned function stops the recording.
+func startTranscript(w *acme.Win, name, sshCmd string) (func(), error) {
+	dir := filepath.Join(*sshDir, logsDir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, time.Now().Format(logName))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(f, "%s --started-- %s\n", time.Now().Format(stampFmt), sshCmd)
+
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		transcribe(w, f, done)
+		fmt.Fprintf(f, "%s --terminated--\n", time.Now().Format(stampFmt))
+		f.Close()
+		close(finished)
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}, nil
+}
+
+// transcribe follows the body of w and writes every new line to out,
+// prefixed with the time it was seen, until done is closed.
+func transcribe(w *acme.Win, out io.Writer, done <-chan bool) {
+	var line []byte
+	buf := make([]byte, 8192)
+	read := func() {
+		for {
+			n, err := w.Read("body", buf)
+			line = append(line, buf[:n]...)
+			if n == 0 || err != nil {
+				break
+			}
+		}
+		for {
+			i := bytes.IndexByte(line, '\n')
+			if i < 0 {
+				return
+			}
+			fmt.Fprintf(out, "%s %s\n", time.Now().Format(stampFmt), line[:i])
+			line = line[i+1:]
+		}
+	}
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			read()
+		case <-done:
+			read()
+			if len(line) > 0 {
+				fmt.Fprintf(out, "%s %s\n", time.Now().Format(stampFmt), line)
+			}
+			return
+		}
+	}
+}
+
+// displayLogs lists the transcripts of the entry name, newest first.
+func displayLogs(name string) {
+	w, _ := acme.New()
+	w.Name("%s/ssh/%s/+logs", MntEnv, name)
+	if name == "" {
+		w.Fprintf("body", "No entry selected\n")
+		w.Ctl("clean")
+		return
+	}
+
+	dir := filepath.Join(*sshDir, logsDir, name)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		w.Fprintf("body", "Cannot read %s: %v\n", dir, err)
+	}
+	var logs []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".log") {
+			logs = append(logs, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(logs)))
+	if len(logs) == 0 {
+		w.Fprintf("body", "No transcripts for %s\n", name)
+	}
+	for _, l := range logs {
+		w.Fprintf("body", "%s\n", filepath.Join(dir, l))
+	}
+	w.Ctl("clean")
+	w.Addr("0,0")
+	w.Ctl("dot=addr")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"plramos.win/9fans/acme"
+)
+
+// trashDir keeps the removed entries, one directory per removal holding
+// the files at the same path they had in the descriptor directory.
+const trashDir = ".trash"
+
+// entryFile names the file of a removal listing what was moved, the
+// descriptor first.
+const entryFile = ".entry"
+
+// removeEntry moves the entry name to the trash. Its sshfs mount and
+// running commands are stopped first.
+func removeEntry(name string, fileSystem fs.FS) error {
+	if name == "" {
+		return fmt.Errorf("no entry selected")
+	}
+	f, err := fileSystem.Open(name)
+	if err != nil {
+		return err
+	}
+	s, _ := parseConfig(f)
+	f.Close()
+
+	mntPoint := fmt.Sprintf("%s/ssh/fs/%s", MntEnv, name)
+	if isMounted(mntPoint) {
+		if err := unmount(mntPoint); err != nil {
+			return err
+		}
+	}
+	remotes.stop(name)
+
+	files := []string{name, filepath.Join(logsDir, name)}
+	if key := managedKey(s.key); key != "" && !keyInUse(key, name, fileSystem) {
+		files = append(files, key)
+	}
+
+	stamp := time.Now().Format("20060102-150405.000")
+	dst := filepath.Join(*sshDir, trashDir, stamp)
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return err
+	}
+	var moved []string
+	defer func() {
+		if len(moved) == 0 {
+			os.RemoveAll(dst)
+			return
+		}
+		os.WriteFile(filepath.Join(dst, entryFile), []byte(strings.Join(moved, "\n")+"\n"), 0600)
+	}()
+	for _, file := range files {
+		ok, err := move(filepath.Join(*sshDir, file), filepath.Join(dst, file))
+		if err != nil {
+			return err
+		}
+		if ok {
+			moved = append(moved, file)
+		}
+	}
+	return nil
+}
+
+// managedKey returns the path of key relative to the descriptor
+// directory, or "" when the key lives elsewhere.
+func managedKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if !filepath.IsAbs(key) {
+		key = filepath.Join(*sshDir, key)
+	}
+	rel, err := filepath.Rel(*sshDir, key)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return rel
+}
+
+// keyInUse reports whether an entry other than name uses key.
+func keyInUse(key, name string, fileSystem fs.FS) bool {
+	inUse := false
+	fs.WalkDir(fileSystem, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || inUse {
+			return nil
+		}
+		if d.IsDir() {
+			if path != "." && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == name || path == key {
+			return nil
+		}
+		f, err := fileSystem.Open(path)
+		if err != nil {
+			return nil
+		}
+		s, _ := parseConfig(f)
+		f.Close()
+		inUse = managedKey(s.key) == key
+		return nil
+	})
+	return inUse
+}
+
+// move renames src to dst creating the directories of dst. It reports
+// false, without error, when there is no src.
+func move(src, dst string) (bool, error) {
+	if _, err := os.Lstat(src); os.IsNotExist(err) {
+		return false, nil
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return false, fmt.Errorf("%s already exists", dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return false, err
+	}
+	return true, os.Rename(src, dst)
+}
+
+func unmount(dir string) error {
+	cmd := exec.Command("umount", dir)
+	if runtime.GOOS == "linux" {
+		cmd = exec.Command("fusermount", "-u", dir)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unmounting %s: %v: %s", dir, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// restoreEntry moves the removal stamp back from the trash.
+func restoreEntry(stamp string) error {
+	src := filepath.Join(*sshDir, trashDir, stamp)
+	b, err := os.ReadFile(filepath.Join(src, entryFile))
+	if err != nil {
+		return err
+	}
+	files := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for _, file := range files {
+		if _, err := move(filepath.Join(src, file), filepath.Join(*sshDir, file)); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(src)
+}
+
+// displayTrash lists the removed entries. Clicking the stamp of one with
+// button 2 or 3 restores it and asks for the list to be refreshed.
+func displayTrash(refresh chan<- bool) {
+	w, err := acme.New()
+	if err != nil {
+		log.Printf("Cannot open trash window: %v", err)
+		return
+	}
+	defer w.CloseFiles()
+	winName := fmt.Sprintf("%s/ssh/+trash", MntEnv)
+	w.Name(winName)
+	w.Fprintf("tag", "Get")
+
+	list := func() map[string]bool {
+		stamps := make(map[string]bool)
+		w.Clear()
+		entries, _ := os.ReadDir(filepath.Join(*sshDir, trashDir))
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() > entries[j].Name() })
+		for _, e := range entries {
+			b, err := os.ReadFile(filepath.Join(*sshDir, trashDir, e.Name(), entryFile))
+			if err != nil {
+				continue
+			}
+			name, _, _ := strings.Cut(string(b), "\n")
+			stamps[e.Name()] = true
+			w.Fprintf("body", "%s\t%s\n", e.Name(), name)
+		}
+		if len(stamps) == 0 {
+			w.Fprintf("body", "Trash is empty\n")
+		}
+		w.Ctl("clean")
+		return stamps
+	}
+
+	stamps := list()
+	for e := range w.EventChan() {
+		switch {
+		case e.C2 == 'x' && string(e.Text) == "Get":
+			stamps = list()
+		case (e.C2 == 'X' || e.C2 == 'L') && stamps[string(e.Text)]:
+			if err := restoreEntry(string(e.Text)); err != nil {
+				acme.Errf(winName, "Cannot restore %s: %v", e.Text, err)
+				continue
+			}
+			stamps = list()
+			select {
+			case refresh <- true:
+			default:
+			}
+		default:
+			w.WriteEvent(e)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package main
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// watchDir sends on c whenever a descriptor below dir is added, removed,
+// renamed or written. Directories created later on are watched as well.
+func watchDir(dir string, c chan<- bool) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	watches := make(map[int32]string)
+	add := func(root string) {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if hidden(dir, path) {
+				return fs.SkipDir
+			}
+			wd, err := syscall.InotifyAddWatch(fd, path, watchMask)
+			if err != nil {
+				log.Printf("Cannot watch %s: %v", path, err)
+				return nil
+			}
+			watches[int32(wd)] = path
+			return nil
+		})
+	}
+	add(dir)
+	if len(watches) == 0 {
+		syscall.Close(fd)
+		return fs.ErrNotExist
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				log.Printf("Stopped watching %s: %v", dir, err)
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				off += syscall.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[off:off+int(ev.Len)]), "\x00")
+				off += int(ev.Len)
+				switch {
+				case ev.Mask&syscall.IN_IGNORED != 0:
+					delete(watches, ev.Wd)
+				case ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+					add(filepath.Join(watches[ev.Wd], name))
+				}
+			}
+			select {
+			case c <- true:
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+// hidden reports whether path, below dir, is or is inside a dot directory,
+// such as the logs and the trash.
+func hidden(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
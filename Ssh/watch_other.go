@@ -0,0 +1,48 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// watchDir sends on c whenever a descriptor below dir is added, removed,
+// renamed or written. Without inotify the directory is polled.
+func watchDir(dir string, c chan<- bool) error {
+	snapshot := func() string {
+		var b strings.Builder
+		fs.WalkDir(os.DirFS(dir), ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && path != "." && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			if info, err := d.Info(); err == nil {
+				fmt.Fprintf(&b, "%s %d\n", path, info.ModTime().UnixNano())
+			}
+			return nil
+		})
+		return b.String()
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	go func() {
+		last := snapshot()
+		for range time.Tick(2 * time.Second) {
+			if s := snapshot(); s != last {
+				last = s
+				select {
+				case c <- true:
+				default:
+				}
+			}
+		}
+	}()
+	return nil
+}